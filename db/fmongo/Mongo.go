@@ -118,6 +118,9 @@ func (umongo *MongoDbUtil) GetDatabase() (client *mongo.Client, db *mongo.Databa
 
 func (umongo *MongoDbUtil) GetCollection() (client *mongo.Client, col *mongo.Collection) {
 	client, db := umongo.GetDatabase()
+	if db == nil {
+		return
+	}
 	col = db.Collection(umongo.CollectionName)
 	return
 }
@@ -250,24 +253,13 @@ func (umongo *MongoDbUtil) defaultCollectionOption() *options.CollectionOptions
 	return &opts
 }
 
-func (umongo *MongoDbUtil) baseUpsert(isUpdate bool, ptrParam interface{}) (newDataId string, err error) {
-	if umongo.useSentry {
-		span := sentry.StartSpan(umongo.Ctx, "MongoDbUtil.Upsert")
-		defer span.Finish()
-	}
-
-	client, err := umongo.connect()
-	if err != nil {
-		return
-	}
-	defer umongo.Disconnect(client)
-
-	col := client.Database(umongo.DbName).Collection(umongo.CollectionName, umongo.defaultCollectionOption())
-
+// ? Stamp createdAt/updatedAt and the document id on ptrParam the same way for every backend.
+func prepareUpsert(isUpdate bool, ptrParam interface{}) (id string, doc interface{}, ok bool) {
 	if reflect.ValueOf(ptrParam).Kind() != reflect.Pointer {
 		log.Println("ptrParam is not pointer")
 		return
 	}
+	doc = ptrParam
 	paramAsReflect := reflect.ValueOf(ptrParam).Elem()
 	if paramAsReflect.Kind() == reflect.Map {
 		(*ptrParam.(*map[string]any))["updatedAt"] = time.Now().UnixMilli()
@@ -300,34 +292,23 @@ func (umongo *MongoDbUtil) baseUpsert(isUpdate bool, ptrParam interface{}) (newD
 		if paramAsReflect.Kind() == reflect.Map {
 			asMap, ok := ptrParam.(*map[string]any)
 			if ok {
-				newDataId = fid.GenerateID()
+				id = fid.GenerateID()
 				if idExists, ok := (*asMap)["_id"].(string); ok {
-					newDataId = idExists
+					id = idExists
 				} else {
-					(*ptrParam.(*map[string]any))["_id"] = newDataId
+					(*ptrParam.(*map[string]any))["_id"] = id
 				}
 			}
 		} else {
-			newDataId = idField.String()
-		}
-
-		if _, err = col.InsertOne(umongo.Ctx, ptrParam); err != nil {
-			log.Println(err)
-			if strings.Contains(err.Error(), "_id_ dup key") {
-				err = errors.New("data is duplicated")
-			} else {
-				err = errors.New("fail Add")
-			}
-			return
+			id = idField.String()
 		}
 	} else {
-		var id string
 		if paramAsReflect.Kind() == reflect.Map {
 			asMap, ok := ptrParam.(*map[string]any)
 			if ok {
 				id = fmt.Sprint((*asMap)["_id"])
 				delete(*asMap, "_id")
-				ptrParam = asMap
+				doc = asMap
 			}
 		} else if !idField.IsValid() {
 			log.Println("Fail to get field ID")
@@ -335,9 +316,45 @@ func (umongo *MongoDbUtil) baseUpsert(isUpdate bool, ptrParam interface{}) (newD
 		} else {
 			id = idField.String()
 		}
+	}
+
+	ok = true
+	return
+}
+
+func (umongo *MongoDbUtil) baseUpsert(isUpdate bool, ptrParam interface{}) (newDataId string, err error) {
+	if umongo.useSentry {
+		span := sentry.StartSpan(umongo.Ctx, "MongoDbUtil.Upsert")
+		defer span.Finish()
+	}
+
+	client, err := umongo.connect()
+	if err != nil {
+		return
+	}
+	defer umongo.Disconnect(client)
+
+	col := client.Database(umongo.DbName).Collection(umongo.CollectionName, umongo.defaultCollectionOption())
+
+	id, doc, ok := prepareUpsert(isUpdate, ptrParam)
+	if !ok {
+		return
+	}
 
+	if !isUpdate {
+		newDataId = id
+		if _, err = col.InsertOne(umongo.Ctx, doc); err != nil {
+			log.Println(err)
+			if strings.Contains(err.Error(), "_id_ dup key") {
+				err = errors.New("data is duplicated")
+			} else {
+				err = errors.New("fail Add")
+			}
+			return
+		}
+	} else {
 		var updateRes *mongo.UpdateResult
-		if updateRes, err = col.UpdateByID(umongo.Ctx, id, bson.M{"$set": ptrParam}, options.Update().SetUpsert(true)); err != nil {
+		if updateRes, err = col.UpdateByID(umongo.Ctx, id, bson.M{"$set": doc}, options.Update().SetUpsert(true)); err != nil {
 			log.Println(err)
 			return
 		} else {
@@ -463,14 +480,23 @@ func (umongo *MongoDbUtil) GjsonFindOne(key, value string) (res gjson.Result, er
 }
 
 func (umongo *MongoDbUtil) GetAggregate(groupStage mongo.Pipeline) (results []bson.M) {
-	_, coll := umongo.GetCollection()
-	cursor, err := coll.Aggregate(context.Background(), groupStage)
+	results, _ = umongo.BaseGetAggregate(groupStage)
+	return
+}
+
+func (umongo *MongoDbUtil) BaseGetAggregate(groupStage mongo.Pipeline) (results []bson.M, err error) {
+	client, coll := umongo.GetCollection()
+	if client == nil {
+		err = errors.New("fail connect to data")
+		return
+	}
+	cursor, err := coll.Aggregate(umongo.Ctx, groupStage)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	if err = cursor.All(context.Background(), &results); err != nil {
+	if err = cursor.All(umongo.Ctx, &results); err != nil {
 		log.Println(err)
 		return
 	}
@@ -543,19 +569,7 @@ func (umongo *MongoDbUtil) MapToList(includeField []string) (res map[string][]an
 	return
 }
 
-func (umongo *MongoDbUtil) FindWrapError(filter bson.M,
-	request interface{}, pointerDecodeTo interface{},
-) (paginationResp *PaginationResponse, err error) {
-	if filter == nil {
-		filter = bson.M{}
-	}
-	client, err := umongo.connect()
-	if err != nil {
-		return
-	}
-	defer umongo.Disconnect(client)
-	col := client.Database(umongo.DbName).Collection(umongo.CollectionName, umongo.defaultCollectionOption())
-
+func paginationFindOptions(filter bson.M, request interface{}) (bson.M, options.FindOptions, Request_Pagination) {
 	var requestPagination Request_Pagination
 	//* ----------------------------- SET FILTER REQUEST ---------------------------- */
 	switch requestAsType := request.(type) {
@@ -600,14 +614,30 @@ func (umongo *MongoDbUtil) FindWrapError(filter bson.M,
 	skip *= requestPagination.Size
 	findOptions.Skip = &skip
 	findOptions.Limit = &requestPagination.Size
+	return filter, findOptions, requestPagination
+}
 
+func (umongo *MongoDbUtil) FindWrapError(filter bson.M,
+	request interface{}, pointerDecodeTo interface{},
+) (paginationResp *PaginationResponse, err error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	client, err := umongo.connect()
+	if err != nil {
+		return
+	}
+	defer umongo.Disconnect(client)
+	col := client.Database(umongo.DbName).Collection(umongo.CollectionName, umongo.defaultCollectionOption())
+
+	filter, findOptions, requestPagination := paginationFindOptions(filter, request)
 	if err = umongo.BaseFind(filter, findOptions, pointerDecodeTo); err != nil {
 		log.Println(err)
 		return
 	}
 
 	//* ------------------------- SET RESPONSE PAGINATION ------------------------ */
-	totalElements, err := col.CountDocuments(umongo.Ctx, filter)
+	totalElements, err := col.CountDocuments(umongo.Ctx, geoCountFilter(filter))
 	if err != nil {
 		log.Println(err)
 		return
@@ -645,6 +675,10 @@ func (umongo *MongoDbUtil) Find(filter bson.M,
 }
 
 func (umongo *MongoDbUtil) CheckDuplicate(id string, listFilterOr []bson.M) (err error) {
+	return checkDuplicate(umongo, id, listFilterOr)
+}
+
+func checkDuplicate(umongo Repository, id string, listFilterOr []bson.M) (err error) {
 	var checkDuplicate bson.M
 	if err := umongo.BaseFindOne(bson.M{"$or": listFilterOr}, &checkDuplicate); err != nil {
 		log.Println(err)
@@ -0,0 +1,327 @@
+package fmongo
+
+import (
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/getsentry/sentry-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultBucketName = "fs"
+
+type FileStore struct {
+	umongo     *MongoDbUtil
+	bucketName string
+	chunkSize  int32
+}
+
+type FileInfo struct {
+	IdDocument string    `bson:"_id" json:"id"`
+	Filename   string    `bson:"filename" json:"filename"`
+	Length     int64     `bson:"length" json:"length"`
+	ChunkSize  int32     `bson:"chunkSize" json:"chunkSize"`
+	UploadDate time.Time `bson:"uploadDate" json:"uploadDate"`
+	Metadata   bson.M    `bson:"metadata,omitempty" json:"metadata,omitempty"`
+}
+
+// ? bucketName empty = "fs", the GridFS default (fs.files + fs.chunks)
+func (umongo *MongoDbUtil) NewFileStore(bucketName string) *FileStore {
+	if bucketName == "" {
+		bucketName = defaultBucketName
+	}
+	return &FileStore{umongo: umongo, bucketName: bucketName}
+}
+
+func NewFileStoreUseEnv(bucketName string) *FileStore {
+	return NewMongoDbUtilUseEnv("").NewFileStore(bucketName)
+}
+
+func (fs *FileStore) SetChunkSize(chunkSize int32) *FileStore {
+	fs.chunkSize = chunkSize
+	return fs
+}
+
+func (fs *FileStore) bucket() (bucket *gridfs.Bucket, err error) {
+	client, err := fs.umongo.connect()
+	if err != nil {
+		return
+	}
+	defer fs.umongo.Disconnect(client)
+
+	bucketOption := options.GridFSBucket().SetName(fs.bucketName)
+	if fs.chunkSize > 0 {
+		bucketOption.SetChunkSizeBytes(fs.chunkSize)
+	}
+	bucket, err = gridfs.NewBucket(client.Database(fs.umongo.DbName), bucketOption)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	return
+}
+
+// ? Filter on the files collection, keys of metadata need the "metadata." prefix.
+func (fs *FileStore) defaultFindFilter(filter bson.M) bson.M {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if _, ok := filter["metadata.status"]; !ok && !fs.umongo.disableFilterStatusArchive {
+		filter["metadata.status"] = bson.M{"$ne": statusArchive}
+	}
+	return filter
+}
+
+func (fs *FileStore) Upload(filename string, source io.Reader, metadata bson.M) (newFileId string, err error) {
+	if fs.umongo.useSentry {
+		span := sentry.StartSpan(fs.umongo.Ctx, "FileStore.Upload")
+		defer span.Finish()
+	}
+
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+
+	metadata = maps.Clone(metadata) //? Don't write into the caller map
+	if metadata == nil {
+		metadata = bson.M{}
+	}
+	now := time.Now().UnixMilli()
+	metadata["createdAt"], metadata["updatedAt"] = now, now
+
+	newFileId = fid.GenerateID()
+	if err = bucket.UploadFromStreamWithID(newFileId, filename, source, options.GridFSUpload().SetMetadata(metadata)); err != nil {
+		log.Println(err)
+		err = errors.New("fail upload")
+		newFileId = ""
+		return
+	}
+	return
+}
+
+func (fs *FileStore) FindOne(id string) (res *FileInfo, err error) {
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+
+	res = &FileInfo{}
+	findRes := bucket.GetFilesCollection().FindOne(fs.umongo.Ctx, fs.defaultFindFilter(bson.M{"_id": id}))
+	if err = findRes.Decode(res); err != nil {
+		log.Println(err, id)
+		res, err = nil, errors.New("data not found")
+		return
+	}
+	return
+}
+
+func (fs *FileStore) Download(id string, destination io.Writer) (written int64, err error) {
+	if fs.umongo.useSentry {
+		span := sentry.StartSpan(fs.umongo.Ctx, "FileStore.Download")
+		defer span.Finish()
+	}
+
+	if _, err = fs.FindOne(id); err != nil { //? Archived file is treated as not found.
+		return
+	}
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+
+	if written, err = bucket.DownloadToStream(id, destination); err != nil {
+		log.Println(err)
+		return
+	}
+	return
+}
+
+type rangeReader struct {
+	io.Reader
+	stream *gridfs.DownloadStream
+}
+
+func (r *rangeReader) Close() error {
+	return r.stream.Close()
+}
+
+// ? length <= 0 read until the end of file. Caller must Close the reader.
+func (fs *FileStore) OpenRange(id string, offset, length int64) (res io.ReadCloser, err error) {
+	fileInfo, err := fs.FindOne(id)
+	if err != nil {
+		return
+	}
+	if offset < 0 || offset > fileInfo.Length {
+		err = errors.New("offset out of range")
+		return
+	}
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+
+	stream, err := bucket.OpenDownloadStream(id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if _, err = stream.Skip(offset); err != nil {
+		log.Println(err)
+		stream.Close()
+		return
+	}
+
+	if length <= 0 || offset+length > fileInfo.Length {
+		length = fileInfo.Length - offset
+	}
+	res = &rangeReader{Reader: io.LimitReader(stream, length), stream: stream}
+	return
+}
+
+func (fs *FileStore) DownloadRange(id string, offset, length int64, destination io.Writer) (written int64, err error) {
+	reader, err := fs.OpenRange(id, offset, length)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	if written, err = io.Copy(destination, reader); err != nil {
+		log.Println(err)
+		return
+	}
+	return
+}
+
+func (fs *FileStore) Find(filter bson.M, request Request_Pagination) (res []FileInfo, paginationResp *PaginationResponse, err error) {
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+	col := bucket.GetFilesCollection()
+
+	findOptions := options.Find()
+	order := -1
+	if strings.ToLower(request.Order) == "asc" {
+		order = 1
+	}
+	if request.OrderBy != "" {
+		findOptions.SetSort(bson.M{request.OrderBy: order})
+	}
+	if len(request.OverwriteSort) > 0 {
+		findOptions.SetSort(request.OverwriteSort)
+	}
+	if request.Size != 0 {
+		skip := request.Page
+		if skip > 0 {
+			skip--
+		}
+		findOptions.SetSkip(skip * request.Size).SetLimit(request.Size)
+	}
+
+	filter = fs.defaultFindFilter(filter)
+	cursor, err := col.Find(fs.umongo.Ctx, filter, findOptions)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = cursor.All(fs.umongo.Ctx, &res); err != nil {
+		log.Println(err)
+		return
+	}
+
+	totalElements, err := col.CountDocuments(fs.umongo.Ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	paginationResp = &PaginationResponse{
+		Size:          int(request.Size),
+		TotalElements: totalElements,
+		TotalPages:    int64(math.Ceil(float64(totalElements) / float64(request.Size))),
+	}
+	if totalElements == 0 {
+		err = errors.New("no data found")
+	}
+	return
+}
+
+// ? Each key is matched against the metadata document, e.g. {"owner": "abc"} => {"metadata.owner": "abc"}
+func (fs *FileStore) FindByMetadata(metadata bson.M, request Request_Pagination) (res []FileInfo, paginationResp *PaginationResponse, err error) {
+	filter := bson.M{}
+	for key, value := range metadata {
+		filter["metadata."+key] = value
+	}
+	return fs.Find(filter, request)
+}
+
+func (fs *FileStore) UpdateMetadata(id string, metadata bson.M) (err error) {
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+
+	set := bson.M{"metadata.updatedAt": time.Now().UnixMilli()}
+	for key, value := range metadata {
+		set["metadata."+key] = value
+	}
+	res, err := bucket.GetFilesCollection().UpdateOne(fs.umongo.Ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if res.MatchedCount == 0 {
+		err = errors.New("data not found")
+	}
+	return
+}
+
+// ? Soft delete, same as MongoDbUtil.DeleteOne. Use Purge to remove the chunks.
+func (fs *FileStore) Delete(id string) (err error) {
+	return fs.UpdateMetadata(id, bson.M{"status": statusArchive})
+}
+
+func (fs *FileStore) Restore(id string) (err error) {
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+
+	res, err := bucket.GetFilesCollection().UpdateOne(fs.umongo.Ctx, bson.M{"_id": id}, bson.M{
+		"$unset": bson.M{"metadata.status": ""},
+		"$set":   bson.M{"metadata.updatedAt": time.Now().UnixMilli()},
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if res.MatchedCount == 0 {
+		err = errors.New("data not found")
+	}
+	return
+}
+
+func (fs *FileStore) Purge(id string) (err error) {
+	bucket, err := fs.bucket()
+	if err != nil {
+		return
+	}
+
+	if err = bucket.DeleteContext(fs.umongo.Ctx, id); err != nil {
+		log.Println(err)
+		if errors.Is(err, gridfs.ErrFileNotFound) || errors.Is(err, mongo.ErrNoDocuments) {
+			err = errors.New("data not found")
+		}
+		return
+	}
+	return
+}
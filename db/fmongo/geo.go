@@ -0,0 +1,239 @@
+package fmongo
+
+import (
+	"errors"
+	"log"
+	"math"
+
+	"github.com/getsentry/sentry-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	GEO_DISTANCE_FIELD = "distance" //? Filled by FindNear, in meter
+	earthRadiusMeter   = 6378100
+)
+
+// ? Coordinates is [longitude, latitude], the GeoJSON order.
+type GeoPoint struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+type GeoPolygon struct {
+	Type        string        `bson:"type" json:"type"`
+	Coordinates [][][]float64 `bson:"coordinates" json:"coordinates"`
+}
+
+func NewGeoPoint(lng, lat float64) GeoPoint {
+	return GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// ? Each point is [longitude, latitude], the ring will be closed automatically.
+func NewGeoPolygon(ring ...[]float64) GeoPolygon {
+	if len(ring) > 0 {
+		first, last := ring[0], ring[len(ring)-1]
+		if len(first) == 2 && len(last) == 2 && (first[0] != last[0] || first[1] != last[1]) {
+			ring = append(ring, first)
+		}
+	}
+	return GeoPolygon{Type: "Polygon", Coordinates: [][][]float64{ring}}
+}
+
+// ? maxDistance in meter, 0 = unlimited. Result is sorted by the nearest.
+// ? e.g. filter := bson.M{"location": fmongo.Near(fmongo.NewGeoPoint(106.8, -6.2), 5000)}
+func Near(point GeoPoint, maxDistance float64) bson.M {
+	nearSphere := bson.M{"$geometry": point}
+	if maxDistance > 0 {
+		nearSphere["$maxDistance"] = maxDistance
+	}
+	return bson.M{"$nearSphere": nearSphere}
+}
+
+func Within(polygon GeoPolygon) bson.M {
+	return bson.M{"$geoWithin": bson.M{"$geometry": polygon}}
+}
+
+// ? Same area as Near but unsorted, and can be used on count.
+func WithinRadius(point GeoPoint, radius float64) bson.M {
+	return bson.M{"$geoWithin": bson.M{
+		"$centerSphere": bson.A{point.Coordinates, radius / earthRadiusMeter},
+	}}
+}
+
+// ? CountDocuments doesn't allow $near/$nearSphere, replace them with the equivalent $geoWithin.
+// ? Also inside $and / $or / $nor.
+func geoCountFilter(filter bson.M) (res bson.M) {
+	res = bson.M{}
+	for key, cond := range filter {
+		res[key] = cond
+
+		if key == "$and" || key == "$or" || key == "$nor" {
+			if list, ok := asList(cond); ok {
+				listFilter := bson.A{}
+				for _, each := range list {
+					if subFilter, ok := asDoc(each); ok {
+						listFilter = append(listFilter, geoCountFilter(subFilter))
+						continue
+					}
+					listFilter = append(listFilter, each)
+				}
+				res[key] = listFilter
+			}
+			continue
+		}
+
+		asMap, ok := cond.(bson.M)
+		if !ok {
+			continue
+		}
+		for _, operator := range []string{"$nearSphere", "$near"} {
+			if legacy, ok := asList(asMap[operator]); ok {
+				res[key] = geoLegacyCountFilter(operator, legacy, asMap["$maxDistance"])
+				continue
+			}
+			near, ok := asDoc(asMap[operator])
+			if !ok {
+				continue
+			}
+
+			var point GeoPoint
+			if raw, err := bson.Marshal(bson.M{"point": near["$geometry"]}); err == nil {
+				_ = bson.Unmarshal(raw, &struct {
+					Point *GeoPoint `bson:"point"`
+				}{&point})
+			}
+			maxDistance, _ := asFloat(near["$maxDistance"])
+			if len(point.Coordinates) != 2 || maxDistance <= 0 {
+				res[key] = bson.M{"$exists": true}
+				continue
+			}
+			res[key] = WithinRadius(point, maxDistance)
+		}
+	}
+	return
+}
+
+// ? Legacy coordinate pair, e.g. {"$near": [lng, lat], "$maxDistance": 0.01}. maxDistance is in radians for $nearSphere,
+// ? in the unit of the coordinates for $near.
+func geoLegacyCountFilter(operator string, legacy []any, rawMaxDistance any) bson.M {
+	maxDistance, _ := asFloat(rawMaxDistance)
+	if len(legacy) != 2 || maxDistance <= 0 {
+		return bson.M{"$exists": true}
+	}
+	shape := "$center"
+	if operator == "$nearSphere" {
+		shape = "$centerSphere"
+	}
+	return bson.M{"$geoWithin": bson.M{shape: bson.A{legacy, maxDistance}}}
+}
+
+func (umongo *MongoDbUtil) CreateGeoIndex(field string) (err error) {
+	client, err := umongo.connect()
+	if err != nil {
+		return
+	}
+	defer umongo.Disconnect(client)
+	col := client.Database(umongo.DbName).Collection(umongo.CollectionName, umongo.defaultCollectionOption())
+
+	indexName, err := col.Indexes().CreateOne(umongo.Ctx, mongo.IndexModel{Keys: bson.D{{Key: field, Value: "2dsphere"}}})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("%s.%s created\n", umongo.CollectionName, indexName)
+	return
+}
+
+// ? $geoNear version of FindWrapError, every document get GEO_DISTANCE_FIELD (meter) and sorted by the nearest
+// ? unless request has OrderBy/OverwriteSort. maxDistance 0 = unlimited.
+func (umongo *MongoDbUtil) FindNear(field string, point GeoPoint, maxDistance float64, filter bson.M,
+	request interface{}, pointerDecodeTo interface{},
+) (paginationResp *PaginationResponse, err error) {
+	if umongo.useSentry {
+		span := sentry.StartSpan(umongo.Ctx, "MongoDbUtil.FindNear")
+		defer span.Finish()
+	}
+
+	if filter == nil {
+		filter = bson.M{}
+	}
+	client, err := umongo.connect()
+	if err != nil {
+		return
+	}
+	defer umongo.Disconnect(client)
+	col := client.Database(umongo.DbName).Collection(umongo.CollectionName, umongo.defaultCollectionOption())
+
+	filter, findOptions, requestPagination := paginationFindOptions(filter, request)
+	filter = umongo.defaultFindFilter(filter)
+
+	geoNear := bson.M{
+		"near":          point,
+		"key":           field,
+		"distanceField": GEO_DISTANCE_FIELD,
+		"spherical":     true,
+		"query":         filter,
+	}
+	if maxDistance > 0 {
+		geoNear["maxDistance"] = maxDistance
+	}
+
+	//* ------------------------------- DATA STAGE ------------------------------- */
+	pipeline := mongo.Pipeline{{{Key: "$geoNear", Value: geoNear}}}
+	if findOptions.Sort != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: findOptions.Sort}})
+	}
+	if size := requestPagination.Size; size > 0 {
+		if skip := max(requestPagination.Page-1, 0) * size; skip > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$skip", Value: skip}})
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: size}})
+	}
+	if len(umongo.projection) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: umongo.projection}})
+	}
+
+	cursor, err := col.Aggregate(umongo.Ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = cursor.All(umongo.Ctx, pointerDecodeTo); err != nil {
+		log.Println(err)
+		return
+	}
+
+	//* ------------------------- SET RESPONSE PAGINATION ------------------------ */
+	countCursor, err := col.Aggregate(umongo.Ctx, mongo.Pipeline{
+		{{Key: "$geoNear", Value: geoNear}},
+		{{Key: "$count", Value: "total"}},
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var listCount []struct {
+		Total int64 `bson:"total"`
+	}
+	if err = countCursor.All(umongo.Ctx, &listCount); err != nil {
+		log.Println(err)
+		return
+	}
+
+	var totalElements int64
+	if len(listCount) > 0 {
+		totalElements = listCount[0].Total
+	}
+	paginationResp = &PaginationResponse{
+		Size:          int(requestPagination.Size),
+		TotalElements: totalElements,
+		TotalPages:    int64(math.Ceil(float64(totalElements) / float64(requestPagination.Size))),
+	}
+	if totalElements == 0 {
+		err = errors.New("no data found")
+		log.Println(err, umongo.CollectionName)
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package fmongo
+
+import (
+	"errors"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/* -------------------------------------------------------------------------- */
+/*                                   DECODE                                   */
+/* -------------------------------------------------------------------------- */
+
+func toDoc(in any) (res bson.M, err error) {
+	raw, err := bson.Marshal(in)
+	if err != nil {
+		return
+	}
+	res = bson.M{}
+	err = bson.Unmarshal(raw, &res)
+	return
+}
+
+func decodeDoc(doc bson.M, pointerDecodeTo any) (err error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+	return bson.Unmarshal(raw, pointerDecodeTo)
+}
+
+func decodeAll(listDoc []bson.M, pointerDecodeTo any) (err error) {
+	ptr := reflect.ValueOf(pointerDecodeTo)
+	if ptr.Kind() != reflect.Pointer || ptr.Elem().Kind() != reflect.Slice {
+		return errors.New("pointerDecodeTo must be a pointer to a slice")
+	}
+
+	sliceType := ptr.Elem().Type()
+	res := reflect.MakeSlice(sliceType, 0, len(listDoc))
+	for _, doc := range listDoc {
+		elem := reflect.New(sliceType.Elem())
+		if err = decodeDoc(doc, elem.Interface()); err != nil {
+			return
+		}
+		res = reflect.Append(res, elem.Elem())
+	}
+	ptr.Elem().Set(res)
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                    VALUE                                   */
+/* -------------------------------------------------------------------------- */
+
+func asDoc(in any) (res bson.M, ok bool) {
+	switch asType := in.(type) {
+	case bson.M:
+		return asType, true
+	case map[string]any:
+		return bson.M(asType), true
+	case bson.D:
+		res = bson.M{}
+		for _, each := range asType {
+			res[each.Key] = each.Value
+		}
+		return res, true
+	}
+	return
+}
+
+func asList(in any) (res []any, ok bool) {
+	value := reflect.ValueOf(in)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return
+	}
+	if _, isDoc := in.(bson.D); isDoc {
+		return
+	}
+	if value.Type().Elem().Kind() == reflect.Uint8 { //? []byte is binary, not array
+		return
+	}
+	for i := 0; i < value.Len(); i++ {
+		res = append(res, value.Index(i).Interface())
+	}
+	return res, true
+}
+
+func asFloat(in any) (res float64, ok bool) {
+	value := reflect.ValueOf(in)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	}
+	return
+}
@@ -0,0 +1,882 @@
+package fmongo
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+)
+
+type memoryStore struct {
+	mutex   sync.RWMutex
+	listDoc map[string][]bson.Raw //? collectionName -> documents, in insertion order
+}
+
+// ? In-memory Repository for unit test, no mongo needed.
+// ? Supported query operator: $eq $ne $in $nin $gt $gte $lt $lte $regex $exists $not $size $elemMatch $and $or $nor
+// ? Supported aggregate stage: $match $sort $skip $limit $project $count, use SetAggregateFunc for anything else.
+type MemoryMongoDbUtil struct {
+	umongo        *MongoDbUtil //? Only hold the settings (collection, projection, default filter)
+	store         *memoryStore
+	aggregateFunc func(pipeline mongo.Pipeline, listDoc []bson.M) []bson.M
+}
+
+func NewMemoryMongoDbUtil(collectionName string) *MemoryMongoDbUtil {
+	return &MemoryMongoDbUtil{
+		umongo: NewMongoDbUtil("", "", collectionName),
+		store:  &memoryStore{listDoc: map[string][]bson.Raw{}},
+	}
+}
+
+// ? New util on another collection sharing the same in-memory database (and aggregate func).
+func (m *MemoryMongoDbUtil) NewCol(collectionName string) *MemoryMongoDbUtil {
+	return &MemoryMongoDbUtil{
+		umongo:        NewMongoDbUtil("", "", collectionName),
+		store:         m.store,
+		aggregateFunc: m.aggregateFunc,
+	}
+}
+
+func (m *MemoryMongoDbUtil) SetCol(col string) *MemoryMongoDbUtil {
+	m.umongo.SetCol(col)
+	return m
+}
+
+func (m *MemoryMongoDbUtil) SetProjection(projection bson.M) *MemoryMongoDbUtil {
+	m.umongo.SetProjection(projection)
+	return m
+}
+
+func (m *MemoryMongoDbUtil) SetCustomDefaultFilter(customDefaultFilter bson.M) *MemoryMongoDbUtil {
+	m.umongo.SetCustomDefaultFilter(customDefaultFilter)
+	return m
+}
+
+func (m *MemoryMongoDbUtil) SetDisableFilterStatusArchive(disableFilterStatusArchive bool) *MemoryMongoDbUtil {
+	m.umongo.SetDisableFilterStatusArchive(disableFilterStatusArchive)
+	return m
+}
+
+func (m *MemoryMongoDbUtil) SetAggregateFunc(aggregateFunc func(pipeline mongo.Pipeline, listDoc []bson.M) []bson.M) *MemoryMongoDbUtil {
+	m.aggregateFunc = aggregateFunc
+	return m
+}
+
+// ? Insert the documents as is, without createdAt/updatedAt/_id generation.
+func (m *MemoryMongoDbUtil) Seed(listDoc ...any) (err error) {
+	m.store.mutex.Lock()
+	defer m.store.mutex.Unlock()
+
+	for _, doc := range listDoc {
+		var raw bson.Raw
+		if raw, err = bson.Marshal(doc); err != nil {
+			log.Println(err)
+			return
+		}
+		m.store.listDoc[m.umongo.CollectionName] = append(m.store.listDoc[m.umongo.CollectionName], raw)
+	}
+	return
+}
+
+// ? All documents of the collection, including the archived one.
+func (m *MemoryMongoDbUtil) Dump() (res []bson.M) {
+	m.store.mutex.RLock()
+	defer m.store.mutex.RUnlock()
+	return m.load()
+}
+
+func (m *MemoryMongoDbUtil) Reset() {
+	m.store.mutex.Lock()
+	defer m.store.mutex.Unlock()
+	delete(m.store.listDoc, m.umongo.CollectionName)
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                   STORAGE                                  */
+/* -------------------------------------------------------------------------- */
+
+// ? Caller must hold the store mutex.
+func (m *MemoryMongoDbUtil) load() (res []bson.M) {
+	for _, raw := range m.store.listDoc[m.umongo.CollectionName] {
+		doc := bson.M{}
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			log.Println(err)
+			continue
+		}
+		res = append(res, doc)
+	}
+	return
+}
+
+// ? Caller must hold the store mutex.
+func (m *MemoryMongoDbUtil) save(listDoc []bson.M) (err error) {
+	listRaw := make([]bson.Raw, 0, len(listDoc))
+	for _, doc := range listDoc {
+		var raw bson.Raw
+		if raw, err = bson.Marshal(doc); err != nil {
+			log.Println(err)
+			return
+		}
+		listRaw = append(listRaw, raw)
+	}
+	m.store.listDoc[m.umongo.CollectionName] = listRaw
+	return
+}
+
+func (m *MemoryMongoDbUtil) find(filter bson.M, findOptions *options.FindOptions) (res []bson.M) {
+	m.store.mutex.RLock()
+	listDoc := m.load()
+	m.store.mutex.RUnlock()
+
+	for _, doc := range listDoc {
+		if memoryMatch(doc, filter) {
+			res = append(res, doc)
+		}
+	}
+	if findOptions == nil {
+		return
+	}
+
+	if findOptions.Sort != nil {
+		memorySort(res, findOptions.Sort)
+	}
+	if findOptions.Skip != nil {
+		res = res[min(int(max(*findOptions.Skip, 0)), len(res)):]
+	}
+	if findOptions.Limit != nil && *findOptions.Limit != 0 {
+		limit := *findOptions.Limit
+		if limit < 0 {
+			limit = -limit
+		}
+		res = res[:min(int(limit), len(res))]
+	}
+	if findOptions.Projection != nil {
+		for i, doc := range res {
+			res[i] = memoryProject(doc, findOptions.Projection)
+		}
+	}
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                 REPOSITORY                                 */
+/* -------------------------------------------------------------------------- */
+
+func (m *MemoryMongoDbUtil) BaseFindOne(filter bson.M, pointerDecodeTo interface{}) (err error) {
+	filter = m.umongo.defaultFindFilter(filter)
+	listDoc := m.find(filter, options.Find().SetLimit(1))
+	if len(listDoc) == 0 {
+		log.Println("data not found", filter)
+		err = errors.New("data not found")
+		return
+	}
+
+	if err := decodeDoc(listDoc[0], pointerDecodeTo); err != nil {
+		log.Println(err)
+	}
+	return
+}
+
+func (m *MemoryMongoDbUtil) FindOne(key, value string, pointerDecodeTo interface{}) (err error) {
+	return m.BaseFindOne(bson.M{key: value}, pointerDecodeTo)
+}
+
+func (m *MemoryMongoDbUtil) BaseFind(filter bson.M, findOptions options.FindOptions, pointerDecodeTo interface{}) (err error) {
+	if len(m.umongo.projection) > 0 {
+		findOptions.SetProjection(m.umongo.projection)
+	}
+
+	filter = m.umongo.defaultFindFilter(filter)
+	if err = decodeAll(m.find(filter, &findOptions), pointerDecodeTo); err != nil {
+		log.Println(err)
+		return
+	}
+	return
+}
+
+func (m *MemoryMongoDbUtil) FindWrapError(filter bson.M,
+	request interface{}, pointerDecodeTo interface{},
+) (paginationResp *PaginationResponse, err error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	filter, findOptions, requestPagination := paginationFindOptions(filter, request)
+	if err = m.BaseFind(filter, findOptions, pointerDecodeTo); err != nil {
+		log.Println(err)
+		return
+	}
+
+	totalElements := int64(len(m.find(filter, nil)))
+	paginationResp = &PaginationResponse{
+		Size:          int(requestPagination.Size),
+		TotalElements: totalElements,
+		TotalPages:    int64(math.Ceil(float64(totalElements) / float64(requestPagination.Size))),
+	}
+
+	if totalElements == 0 {
+		err = errors.New("no data found")
+		log.Println(err, m.umongo.CollectionName)
+	}
+	return
+}
+
+func (m *MemoryMongoDbUtil) Find(filter bson.M,
+	request interface{}, pointerDecodeTo interface{},
+) (paginationResp *PaginationResponse, errMessage string) {
+	paginationResp, err := m.FindWrapError(filter, request, pointerDecodeTo)
+	errMessage = GetErrForResponse(err)
+	return
+}
+
+func (m *MemoryMongoDbUtil) UpsertAndGetId(isUpdate bool, ptrParam interface{}) (newDataId string, err error) {
+	return m.baseUpsert(isUpdate, ptrParam)
+}
+
+func (m *MemoryMongoDbUtil) Upsert(isUpdate bool, ptrParam interface{}) (err error) {
+	_, err = m.baseUpsert(isUpdate, ptrParam)
+	return
+}
+
+func (m *MemoryMongoDbUtil) baseUpsert(isUpdate bool, ptrParam interface{}) (newDataId string, err error) {
+	id, doc, ok := prepareUpsert(isUpdate, ptrParam)
+	if !ok {
+		return
+	}
+	newDoc, err := toDoc(doc)
+	if err != nil {
+		log.Println(err)
+		err = errors.New("fail Add")
+		return
+	}
+	newDoc["_id"] = id
+
+	m.store.mutex.Lock()
+	defer m.store.mutex.Unlock()
+
+	listDoc := m.load()
+	index := slices.IndexFunc(listDoc, func(each bson.M) bool {
+		return memoryEqual(each["_id"], id)
+	})
+	switch {
+	case !isUpdate && index >= 0:
+		err = errors.New("data is duplicated")
+		return
+	case index >= 0:
+		for key, value := range newDoc {
+			listDoc[index][key] = value
+		}
+	default:
+		listDoc = append(listDoc, newDoc)
+	}
+
+	if err = m.save(listDoc); err != nil {
+		return
+	}
+	newDataId = id
+	return
+}
+
+func (m *MemoryMongoDbUtil) update(filter, update bson.M, many bool) (matchedCount, modifiedCount int64, err error) {
+	m.store.mutex.Lock()
+	defer m.store.mutex.Unlock()
+
+	listDoc := m.load()
+	for i, doc := range listDoc {
+		if !memoryMatch(doc, filter) {
+			continue
+		}
+		matchedCount++
+
+		before, _ := bson.Marshal(doc)
+		if listDoc[i], err = memoryApplyUpdate(doc, update); err != nil {
+			log.Println(err)
+			return
+		}
+		if after, _ := bson.Marshal(listDoc[i]); !slices.Equal(before, after) {
+			modifiedCount++
+		}
+		if !many {
+			break
+		}
+	}
+
+	err = m.save(listDoc)
+	return
+}
+
+func (m *MemoryMongoDbUtil) BaseUpdateOne(filter, update bson.M) {
+	matchedCount, modifiedCount, err := m.update(filter, update, false)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("updateRes: matched=%d modified=%d\n", matchedCount, modifiedCount)
+}
+
+func (m *MemoryMongoDbUtil) DeleteOne(key, value string) (err error) {
+	filter := bson.M{key: value}
+	matchedCount, _, err := m.update(filter, m.umongo.getSoftDeleteSetUpdate(), false)
+	if err != nil {
+		return
+	}
+	if matchedCount == 0 {
+		err = errors.New("data not found")
+		fmt.Printf("[%s] filter: %v\n", m.umongo.CollectionName, filter)
+	}
+	return
+}
+
+func (m *MemoryMongoDbUtil) Delete(filter bson.M) (errMessage string) {
+	_, modifiedCount, err := m.update(filter, m.umongo.getSoftDeleteSetUpdate(), true)
+	if err != nil {
+		return
+	}
+	if modifiedCount == 0 {
+		errMessage = "Data not found"
+		fmt.Printf("[%s] filter: %v\n", m.umongo.CollectionName, filter)
+	}
+	return
+}
+
+// ? Panic on an unsupported stage so a broken pipeline fails the test instead of returning nothing.
+func (m *MemoryMongoDbUtil) GetAggregate(groupStage mongo.Pipeline) (results []bson.M) {
+	results, err := m.BaseGetAggregate(groupStage)
+	if err != nil { //? Same as MongoDbUtil, use BaseGetAggregate for the error
+		log.Println(err)
+		return nil
+	}
+	return
+}
+
+func (m *MemoryMongoDbUtil) BaseGetAggregate(groupStage mongo.Pipeline) (results []bson.M, err error) {
+	m.store.mutex.RLock()
+	listDoc := m.load()
+	m.store.mutex.RUnlock()
+
+	if m.aggregateFunc != nil {
+		return m.aggregateFunc(groupStage, listDoc), nil
+	}
+
+	results = listDoc
+	for _, stage := range groupStage {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("invalid stage %v", stage)
+		}
+		switch name, arg := stage[0].Key, stage[0].Value; name {
+		case "$match":
+			filter, _ := asDoc(arg)
+			matched := []bson.M{}
+			for _, doc := range results {
+				if memoryMatch(doc, filter) {
+					matched = append(matched, doc)
+				}
+			}
+			results = matched
+		case "$sort":
+			memorySort(results, arg)
+		case "$skip":
+			skip, _ := asFloat(arg)
+			results = results[min(int(skip), len(results)):]
+		case "$limit":
+			limit, _ := asFloat(arg)
+			results = results[:min(int(limit), len(results))]
+		case "$project":
+			for i, doc := range results {
+				results[i] = memoryProject(doc, arg)
+			}
+		case "$count":
+			results = []bson.M{{fmt.Sprint(arg): int32(len(results))}}
+		default:
+			return nil, fmt.Errorf("unsupported stage %s on MemoryMongoDbUtil, use SetAggregateFunc", name)
+		}
+	}
+	return
+}
+
+func (m *MemoryMongoDbUtil) CheckDuplicate(id string, listFilterOr []bson.M) (err error) {
+	return checkDuplicate(m, id, listFilterOr)
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                    QUERY                                   */
+/* -------------------------------------------------------------------------- */
+
+func isInteger(in any) bool {
+	if in == nil {
+		return true
+	}
+	switch reflect.ValueOf(in).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func memoryTruthy(in any) bool {
+	if asBool, ok := in.(bool); ok {
+		return asBool
+	}
+	number, ok := asFloat(in)
+	return ok && number != 0
+}
+
+// ? Normalize number, date and nested document so values from filter and from storage are comparable.
+func memoryNormalize(in any) any {
+	switch asType := in.(type) { //? Before the number, primitive.DateTime is an int64
+	case primitive.DateTime:
+		return asType.Time().UTC().Truncate(time.Millisecond)
+	case time.Time:
+		return asType.UTC().Truncate(time.Millisecond)
+	}
+	if number, ok := asFloat(in); ok {
+		return number
+	}
+	if doc, ok := asDoc(in); ok {
+		res := map[string]any{}
+		for key, value := range doc {
+			res[key] = memoryNormalize(value)
+		}
+		return res
+	}
+	if list, ok := asList(in); ok {
+		res := []any{}
+		for _, value := range list {
+			res = append(res, memoryNormalize(value))
+		}
+		return res
+	}
+	return in
+}
+
+func memoryLookup(in any, path []string) (res any, found bool) {
+	if len(path) == 0 {
+		return in, true
+	}
+	if doc, ok := asDoc(in); ok {
+		value, ok := doc[path[0]]
+		if !ok {
+			return
+		}
+		return memoryLookup(value, path[1:])
+	}
+	if list, ok := asList(in); ok {
+		if index, err := strconv.Atoi(path[0]); err == nil {
+			if index < 0 || index >= len(list) {
+				return
+			}
+			return memoryLookup(list[index], path[1:])
+		}
+
+		fanOut := bson.A{} //? "items.name" on array of document
+		for _, each := range list {
+			if value, ok := memoryLookup(each, path); ok {
+				fanOut = append(fanOut, value)
+			}
+		}
+		return fanOut, len(fanOut) > 0
+	}
+	return
+}
+
+func memoryEqual(a, b any) bool {
+	return reflect.DeepEqual(memoryNormalize(a), memoryNormalize(b))
+}
+
+// ? Array field match when any of the element match, like mongo does.
+func memoryEqualAny(value, target any) bool {
+	if memoryEqual(value, target) {
+		return true
+	}
+	if list, ok := asList(value); ok {
+		return slices.ContainsFunc(list, func(each any) bool {
+			return memoryEqual(each, target)
+		})
+	}
+	return false
+}
+
+func memoryCompare(a, b any) (res int, ok bool) {
+	switch a := memoryNormalize(a).(type) {
+	case float64:
+		if b, isFloat := memoryNormalize(b).(float64); isFloat {
+			return compareOrdered(a, b), true
+		}
+	case string:
+		if b, isString := b.(string); isString {
+			return strings.Compare(a, b), true
+		}
+	case time.Time:
+		if b, isTime := memoryNormalize(b).(time.Time); isTime {
+			return a.Compare(b), true
+		}
+	}
+	return
+}
+
+func compareOrdered[T float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func memoryCompareAny(value, target any, operator string) bool {
+	check := func(value any) bool {
+		res, ok := memoryCompare(value, target)
+		if !ok {
+			return false
+		}
+		switch operator {
+		case "$gt":
+			return res > 0
+		case "$gte":
+			return res >= 0
+		case "$lt":
+			return res < 0
+		case "$lte":
+			return res <= 0
+		}
+		return false
+	}
+
+	if check(value) {
+		return true
+	}
+	if list, ok := asList(value); ok {
+		return slices.ContainsFunc(list, check)
+	}
+	return false
+}
+
+func memoryMatchRegex(value any, pattern, regexOptions string) bool {
+	flags := ""
+	for _, flag := range regexOptions {
+		if strings.ContainsRune("ims", flag) {
+			flags += string(flag)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	if asString, ok := value.(string); ok {
+		return regex.MatchString(asString)
+	}
+	if list, ok := asList(value); ok {
+		return slices.ContainsFunc(list, func(each any) bool {
+			asString, ok := each.(string)
+			return ok && regex.MatchString(asString)
+		})
+	}
+	return false
+}
+
+func memoryOperator(cond any) (res bson.M, ok bool) {
+	doc, isDoc := asDoc(cond)
+	if !isDoc || len(doc) == 0 {
+		return
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return
+		}
+	}
+	return doc, true
+}
+
+func memoryMatchCond(value any, found bool, cond any) bool {
+	operators, ok := memoryOperator(cond)
+	if !ok {
+		if regex, isRegex := cond.(primitive.Regex); isRegex {
+			return memoryMatchRegex(value, regex.Pattern, regex.Options)
+		}
+		return memoryEqualAny(value, cond)
+	}
+
+	for operator, arg := range operators {
+		switch operator {
+		case "$eq":
+			if !memoryEqualAny(value, arg) {
+				return false
+			}
+		case "$ne":
+			if memoryEqualAny(value, arg) {
+				return false
+			}
+		case "$in", "$nin":
+			list, _ := asList(arg)
+			contains := slices.ContainsFunc(list, func(each any) bool {
+				if regex, isRegex := each.(primitive.Regex); isRegex {
+					return memoryMatchRegex(value, regex.Pattern, regex.Options)
+				}
+				return memoryEqualAny(value, each)
+			})
+			if contains != (operator == "$in") {
+				return false
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			if !memoryCompareAny(value, arg, operator) {
+				return false
+			}
+		case "$exists":
+			if found != memoryTruthy(arg) {
+				return false
+			}
+		case "$regex":
+			regexOptions, _ := operators["$options"].(string)
+			pattern := fmt.Sprint(arg)
+			if regex, isRegex := arg.(primitive.Regex); isRegex {
+				pattern, regexOptions = regex.Pattern, regex.Options+regexOptions
+			}
+			if !memoryMatchRegex(value, pattern, regexOptions) {
+				return false
+			}
+		case "$options":
+			continue
+		case "$not":
+			if memoryMatchCond(value, found, arg) {
+				return false
+			}
+		case "$size":
+			list, isList := asList(value)
+			size, _ := asFloat(arg)
+			if !isList || float64(len(list)) != size {
+				return false
+			}
+		case "$elemMatch":
+			list, _ := asList(value)
+			subFilter, _ := asDoc(arg)
+			_, isOperator := memoryOperator(arg)
+			if !slices.ContainsFunc(list, func(each any) bool {
+				if elemDoc, isDoc := asDoc(each); isDoc && !isOperator {
+					return memoryMatch(elemDoc, subFilter)
+				}
+				return memoryMatchCond(each, true, arg)
+			}) {
+				return false
+			}
+		default:
+			log.Println("unsupported query operator on MemoryMongoDbUtil:", operator)
+			return false
+		}
+	}
+	return true
+}
+
+func memoryMatch(doc bson.M, filter bson.M) bool {
+	for key, cond := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			list, _ := asList(cond)
+			matched := 0
+			for _, each := range list {
+				if subFilter, ok := asDoc(each); ok && memoryMatch(doc, subFilter) {
+					matched++
+				}
+			}
+			if (key == "$and" && matched != len(list)) ||
+				(key == "$or" && matched == 0) ||
+				(key == "$nor" && matched > 0) {
+				return false
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			log.Println("unsupported query operator on MemoryMongoDbUtil:", key)
+			return false
+		}
+
+		value, found := memoryLookup(doc, strings.Split(key, "."))
+		if !memoryMatchCond(value, found, cond) {
+			return false
+		}
+	}
+	return true
+}
+
+/* -------------------------------------------------------------------------- */
+/*                          SORT, PROJECTION, UPDATE                          */
+/* -------------------------------------------------------------------------- */
+
+func memorySortSpec(in any) (res bson.D) {
+	switch asType := in.(type) {
+	case bson.D:
+		return asType
+	}
+	if doc, ok := asDoc(in); ok {
+		listKey := make([]string, 0, len(doc))
+		for key := range doc {
+			listKey = append(listKey, key)
+		}
+		sort.Strings(listKey)
+		for _, key := range listKey {
+			res = append(res, bson.E{Key: key, Value: doc[key]})
+		}
+	}
+	return
+}
+
+// ? Order between type: missing/null < number < string < date < others
+func memorySortCompare(a, b any) int {
+	rank := func(value any) int {
+		switch memoryNormalize(value).(type) {
+		case nil:
+			return 0
+		case float64:
+			return 1
+		case string:
+			return 2
+		case time.Time:
+			return 3
+		}
+		return 4
+	}
+	if rankA, rankB := rank(a), rank(b); rankA != rankB {
+		return rankA - rankB
+	}
+	if res, ok := memoryCompare(a, b); ok {
+		return res
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func memorySort(listDoc []bson.M, sortSpec any) {
+	spec := memorySortSpec(sortSpec)
+	sort.SliceStable(listDoc, func(i, j int) bool {
+		for _, each := range spec {
+			path := strings.Split(each.Key, ".")
+			a, _ := memoryLookup(listDoc[i], path)
+			b, _ := memoryLookup(listDoc[j], path)
+			res := memorySortCompare(a, b)
+			if direction, _ := asFloat(each.Value); direction < 0 {
+				res = -res
+			}
+			if res != 0 {
+				return res < 0
+			}
+		}
+		return false
+	})
+}
+
+// ? Only top-level field is supported.
+func memoryProject(doc bson.M, projection any) (res bson.M) {
+	spec, ok := asDoc(projection)
+	if !ok || len(spec) == 0 {
+		return doc
+	}
+
+	isInclude := false
+	for key, value := range spec {
+		if key != "_id" && memoryTruthy(value) {
+			isInclude = true
+		}
+	}
+
+	res = bson.M{}
+	if isInclude {
+		for key, value := range spec {
+			if fieldValue, found := doc[key]; found && memoryTruthy(value) {
+				res[key] = fieldValue
+			}
+		}
+		if idValue, found := spec["_id"]; (!found || memoryTruthy(idValue)) && doc["_id"] != nil {
+			res["_id"] = doc["_id"]
+		}
+		return
+	}
+
+	for key, value := range doc {
+		if excluded, found := spec[key]; !found || memoryTruthy(excluded) {
+			res[key] = value
+		}
+	}
+	return
+}
+
+func memorySetPath(doc bson.M, path []string, value any) {
+	if len(path) == 1 {
+		doc[path[0]] = value
+		return
+	}
+	child, ok := asDoc(doc[path[0]])
+	if !ok {
+		child = bson.M{}
+	}
+	memorySetPath(child, path[1:], value)
+	doc[path[0]] = child
+}
+
+func memoryUnsetPath(doc bson.M, path []string) {
+	if len(path) == 1 {
+		delete(doc, path[0])
+		return
+	}
+	if child, ok := asDoc(doc[path[0]]); ok {
+		memoryUnsetPath(child, path[1:])
+		doc[path[0]] = child
+	}
+}
+
+func memoryApplyUpdate(doc bson.M, update bson.M) (res bson.M, err error) {
+	res = doc
+	for operator, arg := range update {
+		fields, ok := asDoc(arg)
+		if !ok {
+			fields, err = toDoc(arg) //? $set with struct
+			if err != nil {
+				return
+			}
+		}
+
+		for key, value := range fields {
+			path := strings.Split(key, ".")
+			switch operator {
+			case "$set":
+				memorySetPath(res, path, value)
+			case "$unset":
+				memoryUnsetPath(res, path)
+			case "$inc":
+				current, _ := memoryLookup(res, path)
+				currentAsFloat, _ := asFloat(current)
+				incAsFloat, _ := asFloat(value)
+				sum := any(currentAsFloat + incAsFloat)
+				if isInteger(current) && isInteger(value) {
+					sum = int64(currentAsFloat + incAsFloat)
+				}
+				memorySetPath(res, path, sum)
+			case "$push":
+				current, _ := memoryLookup(res, path)
+				list, _ := asList(current)
+				memorySetPath(res, path, append(bson.A(list), value))
+			default:
+				err = errors.New("unsupported update operator on MemoryMongoDbUtil: " + operator)
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,388 @@
+package fmongo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type memoryTestOutlet struct {
+	IdDocument string `bson:"_id"`
+	Name       string `bson:"name"`
+	Email      string `bson:"email"`
+	CreatedAt  int64  `bson:"createdAt"`
+	UpdatedAt  int64  `bson:"updatedAt"`
+}
+
+func newMemoryTest(t *testing.T) *MemoryMongoDbUtil {
+	t.Helper()
+	m := NewMemoryMongoDbUtil("outlet")
+	err := m.Seed(
+		bson.M{"_id": "a", "name": "Alpha", "city": "jkt", "rating": 4.5, "stock": int32(10),
+			"tags": bson.A{"coffee", "wifi"}, "items": bson.A{bson.M{"name": "latte", "price": 30}}, "address": bson.M{"zip": "10110"}},
+		bson.M{"_id": "b", "name": "beta", "city": "bdg", "rating": 3.0, "stock": int32(0),
+			"tags": bson.A{"tea"}, "items": bson.A{bson.M{"name": "tea", "price": 15}}},
+		bson.M{"_id": "c", "name": "Gamma", "city": "jkt", "rating": 5, "stock": int32(3),
+			"tags": bson.A{}, "status": "archive"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func memoryTestIds(listDoc []bson.M) string {
+	listId := make([]string, 0, len(listDoc))
+	for _, doc := range listDoc {
+		listId = append(listId, doc["_id"].(string))
+	}
+	return strings.Join(listId, ",")
+}
+
+func memoryTestFind(t *testing.T, m *MemoryMongoDbUtil, filter bson.M, findOptions *options.FindOptions) string {
+	t.Helper()
+	if findOptions == nil {
+		findOptions = options.Find()
+	}
+	var res []bson.M
+	if err := m.BaseFind(filter, *findOptions, &res); err != nil {
+		t.Fatal(err)
+	}
+	return memoryTestIds(res)
+}
+
+func TestMemoryFilter(t *testing.T) {
+	testCases := []struct {
+		name   string
+		filter bson.M
+		want   string
+	}{
+		{"implicit eq", bson.M{"city": "jkt"}, "a,c"},
+		{"$eq number type", bson.M{"rating": bson.M{"$eq": 3}}, "b"},
+		{"$ne", bson.M{"city": bson.M{"$ne": "jkt"}}, "b"},
+		{"$in", bson.M{"city": bson.M{"$in": bson.A{"bdg", "sby"}}}, "b"},
+		{"$in regex", bson.M{"name": bson.M{"$in": bson.A{primitive.Regex{Pattern: "^g", Options: "i"}}}}, "c"},
+		{"$nin", bson.M{"city": bson.M{"$nin": bson.A{"jkt"}}}, "b"},
+		{"$gt", bson.M{"rating": bson.M{"$gt": 4}}, "a,c"},
+		{"$gte", bson.M{"rating": bson.M{"$gte": 4.5}}, "a,c"},
+		{"$lt", bson.M{"rating": bson.M{"$lt": 4}}, "b"},
+		{"$lte", bson.M{"rating": bson.M{"$lte": 4.5}}, "a,b"},
+		{"$gt string", bson.M{"name": bson.M{"$gt": "B"}}, "b,c"},
+		{"$regex with $options", bson.M{"name": bson.M{"$regex": "^a", "$options": "i"}}, "a"},
+		{"regex value", bson.M{"name": primitive.Regex{Pattern: "a$"}}, "a,b,c"},
+		{"$exists true", bson.M{"address": bson.M{"$exists": true}}, "a"},
+		{"$exists false", bson.M{"address": bson.M{"$exists": false}}, "b,c"},
+		{"$not", bson.M{"rating": bson.M{"$not": bson.M{"$gt": 4}}}, "b"},
+		{"$size", bson.M{"tags": bson.M{"$size": 2}}, "a"},
+		{"$size empty", bson.M{"tags": bson.M{"$size": 0}}, "c"},
+		{"$elemMatch document", bson.M{"items": bson.M{"$elemMatch": bson.M{"price": bson.M{"$gte": 20}}}}, "a"},
+		{"$elemMatch operator", bson.M{"tags": bson.M{"$elemMatch": bson.M{"$eq": "tea"}}}, "b"},
+		{"array contains", bson.M{"tags": "wifi"}, "a"},
+		{"dotted path", bson.M{"address.zip": "10110"}, "a"},
+		{"dotted path in array", bson.M{"items.name": "tea"}, "b"},
+		{"array index", bson.M{"tags.1": "wifi"}, "a"},
+		{"$and", bson.M{"$and": bson.A{bson.M{"city": "jkt"}, bson.M{"rating": bson.M{"$gt": 4.6}}}}, "c"},
+		{"$or", bson.M{"$or": bson.A{bson.M{"city": "bdg"}, bson.M{"rating": 5}}}, "b,c"},
+		{"$nor", bson.M{"$nor": bson.A{bson.M{"city": "jkt"}}}, "b"},
+		{"unsupported operator", bson.M{"rating": bson.M{"$mod": bson.A{2, 0}}}, ""},
+	}
+
+	m := newMemoryTest(t).SetDisableFilterStatusArchive(true)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := memoryTestFind(t, m, testCase.filter, nil); got != testCase.want {
+				t.Errorf("got %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestMemoryFilterDate(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 8, 0, 0, 0, time.UTC) }
+	m := NewMemoryMongoDbUtil("outlet").SetDisableFilterStatusArchive(true)
+	err := m.Seed(
+		bson.M{"_id": "a", "openedAt": day(1)},
+		bson.M{"_id": "b", "openedAt": day(2)},
+		bson.M{"_id": "c", "openedAt": primitive.NewDateTimeFromTime(day(3))},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		filter bson.M
+		want   string
+	}{
+		{"equality", bson.M{"openedAt": day(2)}, "b"},
+		{"equality in another zone", bson.M{"openedAt": day(2).In(time.FixedZone("WIB", 7*3600))}, "b"},
+		{"equality primitive.DateTime", bson.M{"openedAt": primitive.NewDateTimeFromTime(day(1))}, "a"},
+		{"$gte", bson.M{"openedAt": bson.M{"$gte": day(2)}}, "b,c"},
+		{"$lt", bson.M{"openedAt": bson.M{"$lt": day(2)}}, "a"},
+		{"range", bson.M{"openedAt": bson.M{"$gt": day(1), "$lte": day(3)}}, "b,c"},
+		{"$in", bson.M{"openedAt": bson.M{"$in": bson.A{day(1), day(3)}}}, "a,c"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := memoryTestFind(t, m, testCase.filter, options.Find().SetSort(bson.M{"_id": 1})); got != testCase.want {
+				t.Errorf("got %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestMemoryFilterStatusArchive(t *testing.T) {
+	m := newMemoryTest(t)
+	if got := memoryTestFind(t, m, bson.M{}, nil); got != "a,b" {
+		t.Errorf("default filter: got %q, want %q", got, "a,b")
+	}
+	if got := memoryTestFind(t, m, bson.M{"status": statusArchive}, nil); got != "c" {
+		t.Errorf("archive: got %q, want %q", got, "c")
+	}
+}
+
+func TestMemorySortSkipLimit(t *testing.T) {
+	testCases := []struct {
+		name        string
+		findOptions *options.FindOptions
+		want        string
+	}{
+		{"no option", options.Find(), "a,b,c"},
+		{"sort asc", options.Find().SetSort(bson.M{"rating": 1}), "b,a,c"},
+		{"sort desc", options.Find().SetSort(bson.M{"rating": -1}), "c,a,b"},
+		{"sort multiple", options.Find().SetSort(bson.D{{Key: "city", Value: 1}, {Key: "rating", Value: -1}}), "b,c,a"},
+		{"sort missing first", options.Find().SetSort(bson.M{"address.zip": 1}), "b,c,a"},
+		{"skip", options.Find().SetSort(bson.M{"rating": 1}).SetSkip(1), "a,c"},
+		{"limit", options.Find().SetSort(bson.M{"rating": 1}).SetLimit(2), "b,a"},
+		{"negative limit", options.Find().SetLimit(-1), "a"},
+		{"skip and limit", options.Find().SetSort(bson.M{"rating": 1}).SetSkip(1).SetLimit(1), "a"},
+		{"skip over", options.Find().SetSkip(10), ""},
+	}
+
+	m := newMemoryTest(t).SetDisableFilterStatusArchive(true)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := memoryTestFind(t, m, bson.M{}, testCase.findOptions); got != testCase.want {
+				t.Errorf("got %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestMemoryProjection(t *testing.T) {
+	m := newMemoryTest(t).SetProjection(bson.M{"name": 1})
+	var res []bson.M
+	if err := m.BaseFind(bson.M{"_id": "a"}, options.FindOptions{}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || len(res[0]) != 2 || res[0]["name"] != "Alpha" || res[0]["_id"] != "a" {
+		t.Errorf("got %v", res)
+	}
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		update bson.M
+		path   string
+		want   any //? nil = removed
+	}{
+		{"$set", bson.M{"$set": bson.M{"name": "Alpha 2"}}, "name", "Alpha 2"},
+		{"$set nested", bson.M{"$set": bson.M{"address.city": "jkt"}}, "address.city", "jkt"},
+		{"$set new document", bson.M{"$set": bson.M{"owner.name": "x"}}, "owner.name", "x"},
+		{"$unset", bson.M{"$unset": bson.M{"address": ""}}, "address", nil},
+		{"$inc int", bson.M{"$inc": bson.M{"stock": 2}}, "stock", int64(12)},
+		{"$inc float", bson.M{"$inc": bson.M{"rating": 0.25}}, "rating", 4.75},
+		{"$inc missing", bson.M{"$inc": bson.M{"visit": 1}}, "visit", int64(1)},
+		{"$push", bson.M{"$push": bson.M{"tags": "pos"}}, "tags", bson.A{"coffee", "wifi", "pos"}},
+		{"$push missing", bson.M{"$push": bson.M{"listOwner": "x"}}, "listOwner", bson.A{"x"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			m := newMemoryTest(t)
+			matchedCount, modifiedCount, err := m.update(bson.M{"_id": "a"}, testCase.update, false)
+			if err != nil || matchedCount != 1 || modifiedCount != 1 {
+				t.Fatalf("matched=%d modified=%d err=%v", matchedCount, modifiedCount, err)
+			}
+
+			var doc bson.M
+			if err = m.FindOne("_id", "a", &doc); err != nil {
+				t.Fatal(err)
+			}
+			got, found := memoryLookup(doc, strings.Split(testCase.path, "."))
+			if testCase.want == nil {
+				if found {
+					t.Errorf("%s still exists: %v", testCase.path, got)
+				}
+				return
+			}
+			if !memoryEqual(got, testCase.want) {
+				t.Errorf("got %#v, want %#v", got, testCase.want)
+			}
+			if _, isInt := testCase.want.(int64); isInt && !isInteger(got) {
+				t.Errorf("got %T, want an integer", got)
+			}
+		})
+	}
+}
+
+func TestMemoryUpdateMany(t *testing.T) {
+	m := newMemoryTest(t)
+	if matchedCount, _, _ := m.update(bson.M{"city": "jkt"}, bson.M{"$set": bson.M{"open": true}}, true); matchedCount != 2 {
+		t.Errorf("many: matched %d, want 2", matchedCount)
+	}
+	if matchedCount, _, _ := m.update(bson.M{"city": "jkt"}, bson.M{"$set": bson.M{"open": false}}, false); matchedCount != 1 {
+		t.Errorf("one: matched %d, want 1", matchedCount)
+	}
+	if _, _, err := m.update(bson.M{"_id": "a"}, bson.M{"$rename": bson.M{"name": "title"}}, false); err == nil {
+		t.Error("unsupported update operator: want an error")
+	}
+}
+
+func TestMemoryDelete(t *testing.T) {
+	m := newMemoryTest(t)
+	if err := m.DeleteOne("_id", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := memoryTestFind(t, m, bson.M{}, nil); got != "b" {
+		t.Errorf("after DeleteOne: got %q, want %q", got, "b")
+	}
+	if errMessage := m.Delete(bson.M{"_id": "x"}); errMessage == "" {
+		t.Error("Delete of nothing: want an error message")
+	}
+}
+
+func TestMemoryUpsert(t *testing.T) {
+	m := NewMemoryMongoDbUtil("outlet")
+
+	outlet := memoryTestOutlet{Name: "Alpha", Email: "a@mail.com"}
+	id, err := m.UpsertAndGetId(false, &outlet)
+	if err != nil || id == "" || outlet.IdDocument != id || outlet.CreatedAt == 0 {
+		t.Fatalf("insert: id=%q outlet=%+v err=%v", id, outlet, err)
+	}
+	if err = m.Upsert(false, &outlet); err == nil || err.Error() != "data is duplicated" {
+		t.Errorf("insert twice: got %v, want data is duplicated", err)
+	}
+
+	outlet.Name = "Alpha 2"
+	if err = m.Upsert(true, &outlet); err != nil {
+		t.Fatal(err)
+	}
+	var found memoryTestOutlet
+	if err = m.FindOne("_id", id, &found); err != nil || found.Name != "Alpha 2" || found.CreatedAt != outlet.CreatedAt {
+		t.Errorf("update: got %+v err=%v", found, err)
+	}
+
+	asMap := map[string]any{"name": "Beta"}
+	mapId, err := m.UpsertAndGetId(false, &asMap)
+	if err != nil || mapId == "" {
+		t.Fatalf("insert map: id=%q err=%v", mapId, err)
+	}
+	if listDoc := m.Dump(); len(listDoc) != 2 || listDoc[1]["name"] != "Beta" || listDoc[1]["createdAt"] == nil {
+		t.Errorf("dump: got %v", listDoc)
+	}
+}
+
+func TestMemoryCheckDuplicate(t *testing.T) {
+	m := NewMemoryMongoDbUtil("user")
+	err := m.Seed(
+		bson.M{"_id": "a", "username": "alpha", "email": "a@mail.com"},
+		bson.M{"_id": "b", "username": "beta", "email": "b@mail.com"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		id           string
+		listFilterOr []bson.M
+		wantErr      string
+	}{
+		{"new unique", "", []bson.M{{"username": "gamma"}, {"email": "c@mail.com"}}, ""},
+		{"new duplicate username", "", []bson.M{{"username": "alpha"}}, "username already used, please use another username"},
+		{"new duplicate email", "", []bson.M{{"email": "b@mail.com"}}, "email is already exists, and need to be unique"},
+		{"update keep own value", "a", []bson.M{{"username": "alpha"}}, ""},
+		{"update take another value", "a", []bson.M{{"email": "b@mail.com"}}, "email is already exists, and need to be unique"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := m.CheckDuplicate(testCase.id, testCase.listFilterOr)
+			if got := GetErrForResponse(err); (err == nil) != (testCase.wantErr == "") || (err != nil && err.Error() != testCase.wantErr) {
+				t.Errorf("got %q, want %q", got, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryAggregate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pipeline mongo.Pipeline
+		want     string
+	}{
+		{"$match", mongo.Pipeline{{{Key: "$match", Value: bson.M{"city": "jkt"}}}}, "a,c"},
+		{"$sort", mongo.Pipeline{{{Key: "$sort", Value: bson.D{{Key: "rating", Value: -1}}}}}, "c,a,b"},
+		{"$skip", mongo.Pipeline{{{Key: "$skip", Value: 2}}}, "c"},
+		{"$limit", mongo.Pipeline{{{Key: "$limit", Value: int64(1)}}}, "a"},
+		{"$match $sort $skip $limit", mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"rating": bson.M{"$gte": 3}}}},
+			{{Key: "$sort", Value: bson.M{"rating": 1}}},
+			{{Key: "$skip", Value: 1}},
+			{{Key: "$limit", Value: 1}},
+		}, "a"},
+	}
+
+	m := newMemoryTest(t)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			res, err := m.BaseGetAggregate(testCase.pipeline)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := memoryTestIds(res); got != testCase.want {
+				t.Errorf("got %q, want %q", got, testCase.want)
+			}
+		})
+	}
+
+	t.Run("$project", func(t *testing.T) {
+		res := m.GetAggregate(mongo.Pipeline{{{Key: "$project", Value: bson.M{"name": 1, "_id": 0}}}})
+		if len(res) != 3 || len(res[0]) != 1 || res[0]["name"] != "Alpha" {
+			t.Errorf("got %v", res)
+		}
+	})
+	t.Run("$count", func(t *testing.T) {
+		res := m.GetAggregate(mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"city": "jkt"}}},
+			{{Key: "$count", Value: "total"}},
+		})
+		if len(res) != 1 || res[0]["total"] != int32(2) {
+			t.Errorf("got %v", res)
+		}
+	})
+	t.Run("unsupported stage", func(t *testing.T) {
+		pipeline := mongo.Pipeline{{{Key: "$group", Value: bson.M{"_id": "$city"}}}}
+		if _, err := m.BaseGetAggregate(pipeline); err == nil {
+			t.Error("want an error")
+		}
+		if res := m.GetAggregate(pipeline); res != nil {
+			t.Errorf("GetAggregate: got %v, want nil", res)
+		}
+	})
+	t.Run("aggregate func kept by NewCol", func(t *testing.T) {
+		withFunc := NewMemoryMongoDbUtil("outlet").SetAggregateFunc(func(pipeline mongo.Pipeline, listDoc []bson.M) []bson.M {
+			return []bson.M{{"_id": "custom"}}
+		})
+		res := withFunc.NewCol("other").GetAggregate(mongo.Pipeline{{{Key: "$group", Value: bson.M{}}}})
+		if got := memoryTestIds(res); got != "custom" {
+			t.Errorf("got %q, want custom", got)
+		}
+	})
+}
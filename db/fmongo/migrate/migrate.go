@@ -0,0 +1,375 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dansbeer/go-forge/db/fmongo"
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DEFAULT_COLLECTION = "migrations"
+	DEFAULT_LOCK_TTL   = 10 * time.Minute
+	lockId             = "lock"
+)
+
+var (
+	ErrLocked   = errors.New("migration is locked by another instance")
+	ErrLockLost = errors.New("migration lock lost, stopped before the next migration")
+)
+
+// ? Each Up/Down receive their own copy of MongoDbUtil, so SetCol is safe to call.
+type Migration struct {
+	Version     int64
+	Description string
+	Up          func(umongo *fmongo.MongoDbUtil) error
+	Down        func(umongo *fmongo.MongoDbUtil) error
+}
+
+type Record struct {
+	Version     int64  `bson:"_id" json:"version"`
+	Description string `bson:"description" json:"description"`
+	AppliedAt   int64  `bson:"appliedAt" json:"appliedAt"`
+	DurationMs  int64  `bson:"durationMs" json:"durationMs"`
+}
+
+type Status struct {
+	Version     int64  `json:"version"`
+	Description string `json:"description"`
+	Applied     bool   `json:"applied"`
+	AppliedAt   int64  `json:"appliedAt,omitempty"`
+	Missing     bool   `json:"missing,omitempty"` //? Applied in db but not registered anymore
+}
+
+type Migrator struct {
+	umongo         *fmongo.MongoDbUtil
+	collectionName string
+	lockTTL        time.Duration
+	dryRun         bool
+	listMigration  []Migration
+}
+
+func New(umongo *fmongo.MongoDbUtil, listMigration ...Migration) *Migrator {
+	m := &Migrator{
+		umongo:         umongo,
+		collectionName: DEFAULT_COLLECTION,
+		lockTTL:        DEFAULT_LOCK_TTL,
+	}
+	return m.Register(listMigration...)
+}
+
+func NewUseEnv(listMigration ...Migration) *Migrator {
+	return New(fmongo.NewMongoDbUtilUseEnv(""), listMigration...)
+}
+
+func (m *Migrator) Register(listMigration ...Migration) *Migrator {
+	m.listMigration = append(m.listMigration, listMigration...)
+	sort.SliceStable(m.listMigration, func(i, j int) bool {
+		return m.listMigration[i].Version < m.listMigration[j].Version
+	})
+	return m
+}
+
+func (m *Migrator) SetCollection(collectionName string) *Migrator {
+	m.collectionName = collectionName
+	return m
+}
+
+func (m *Migrator) SetLockTTL(lockTTL time.Duration) *Migrator {
+	m.lockTTL = lockTTL
+	return m
+}
+
+// ? Dry run only report what would be applied / rolled back, nothing is executed.
+func (m *Migrator) SetDryRun(dryRun bool) *Migrator {
+	m.dryRun = dryRun
+	return m
+}
+
+func (m *Migrator) database() (db *mongo.Database, err error) {
+	if _, db = m.umongo.GetDatabase(); db == nil {
+		err = errors.New("fail connect to data")
+	}
+	return
+}
+
+func (m *Migrator) validate() (err error) {
+	for i, migration := range m.listMigration {
+		if migration.Up == nil {
+			return fmt.Errorf("migration %d has no Up", migration.Version)
+		}
+		if i > 0 && m.listMigration[i-1].Version == migration.Version {
+			return fmt.Errorf("migration %d is registered twice", migration.Version)
+		}
+	}
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                    LOCK                                    */
+/* -------------------------------------------------------------------------- */
+
+// ? The lock is renewed every lockTTL/3 while the migrations run, lost is closed when a renewal fails
+// ? (another instance may take over), the runner stop before the next migration.
+func (m *Migrator) lock(db *mongo.Database) (lost <-chan struct{}, release func(), err error) {
+	col := db.Collection(m.collectionName + "_lock")
+	owner, now := fid.GenerateID(), time.Now()
+	lockDoc := bson.M{"_id": lockId, "owner": owner, "expiresAt": now.Add(m.lockTTL).UnixMilli()}
+
+	if _, err = col.InsertOne(m.umongo.Ctx, lockDoc); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			log.Println(err)
+			return
+		}
+
+		//? Take over the lock when the previous owner died without releasing it.
+		var updateRes *mongo.UpdateResult
+		updateRes, err = col.UpdateOne(m.umongo.Ctx,
+			bson.M{"_id": lockId, "expiresAt": bson.M{"$lt": now.UnixMilli()}},
+			bson.M{"$set": lockDoc})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if updateRes.MatchedCount == 0 {
+			err = ErrLocked
+			return
+		}
+	}
+
+	lostChan, stop, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(max(m.lockTTL/3, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			updateRes, err := col.UpdateOne(m.umongo.Ctx,
+				bson.M{"_id": lockId, "owner": owner},
+				bson.M{"$set": bson.M{"expiresAt": time.Now().Add(m.lockTTL).UnixMilli()}})
+			if err == nil && updateRes.MatchedCount == 0 {
+				err = ErrLockLost
+			}
+			if err != nil {
+				log.Println(aurora.Red(err))
+				close(lostChan)
+				return
+			}
+		}
+	}()
+
+	release = func() {
+		close(stop)
+		<-done
+		if _, err := col.DeleteOne(m.umongo.Ctx, bson.M{"_id": lockId, "owner": owner}); err != nil {
+			log.Println(err)
+		}
+	}
+	return lostChan, release, nil
+}
+
+func isLost(lost <-chan struct{}) bool {
+	select {
+	case <-lost:
+		return true
+	default:
+		return false
+	}
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                   RUNNER                                   */
+/* -------------------------------------------------------------------------- */
+
+func (m *Migrator) applied(db *mongo.Database) (res map[int64]Record, err error) {
+	cursor, err := db.Collection(m.collectionName).Find(m.umongo.Ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var listRecord []Record
+	if err = cursor.All(m.umongo.Ctx, &listRecord); err != nil {
+		log.Println(err)
+		return
+	}
+
+	res = map[int64]Record{}
+	for _, record := range listRecord {
+		res[record.Version] = record
+	}
+	return
+}
+
+func (m *Migrator) run(fn func(umongo *fmongo.MongoDbUtil) error) error {
+	umongo := fmongo.NewMongoDbUtilByStruct(*m.umongo)
+	return fn(umongo)
+}
+
+// ? Apply every pending migration.
+func (m *Migrator) Up() (listVersion []int64, err error) {
+	return m.UpTo(0)
+}
+
+// ? Apply pending migrations up to and including targetVersion, 0 = latest.
+func (m *Migrator) UpTo(targetVersion int64) (listVersion []int64, err error) {
+	if err = m.validate(); err != nil {
+		return
+	}
+	db, err := m.database()
+	if err != nil {
+		return
+	}
+	lost, release, err := m.lock(db)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	applied, err := m.applied(db)
+	if err != nil {
+		return
+	}
+
+	col := db.Collection(m.collectionName)
+	for _, migration := range m.listMigration {
+		if targetVersion > 0 && migration.Version > targetVersion {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if isLost(lost) {
+			err = ErrLockLost
+			return
+		}
+		listVersion = append(listVersion, migration.Version)
+		if m.dryRun {
+			log.Println(aurora.Yellow(fmt.Sprintf("migrate.up (dry run): %d %s", migration.Version, migration.Description)))
+			continue
+		}
+
+		log.Println(aurora.Green(fmt.Sprintf("migrate.up: %d %s", migration.Version, migration.Description)))
+		start := time.Now()
+		if err = m.run(migration.Up); err != nil {
+			log.Println(aurora.Red(err))
+			err = fmt.Errorf("migration %d up: %w", migration.Version, err)
+			listVersion = listVersion[:len(listVersion)-1]
+			return
+		}
+
+		if _, err = col.InsertOne(m.umongo.Ctx, Record{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UnixMilli(),
+			DurationMs:  time.Since(start).Milliseconds(),
+		}); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	return
+}
+
+// ? Roll back the last `steps` applied migrations, newest first.
+func (m *Migrator) Down(steps int) (listVersion []int64, err error) {
+	if err = m.validate(); err != nil {
+		return
+	}
+	db, err := m.database()
+	if err != nil {
+		return
+	}
+	lost, release, err := m.lock(db)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	applied, err := m.applied(db)
+	if err != nil {
+		return
+	}
+
+	col := db.Collection(m.collectionName)
+	for i := len(m.listMigration) - 1; i >= 0 && len(listVersion) < steps; i-- {
+		migration := m.listMigration[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			err = fmt.Errorf("migration %d has no Down", migration.Version)
+			return
+		}
+
+		if isLost(lost) {
+			err = ErrLockLost
+			return
+		}
+		listVersion = append(listVersion, migration.Version)
+		if m.dryRun {
+			log.Println(aurora.Yellow(fmt.Sprintf("migrate.down (dry run): %d %s", migration.Version, migration.Description)))
+			continue
+		}
+
+		log.Println(aurora.Green(fmt.Sprintf("migrate.down: %d %s", migration.Version, migration.Description)))
+		if err = m.run(migration.Down); err != nil {
+			log.Println(aurora.Red(err))
+			err = fmt.Errorf("migration %d down: %w", migration.Version, err)
+			listVersion = listVersion[:len(listVersion)-1]
+			return
+		}
+
+		if _, err = col.DeleteOne(m.umongo.Ctx, bson.M{"_id": migration.Version}); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	return
+}
+
+func (m *Migrator) Status() (res []Status, err error) {
+	db, err := m.database()
+	if err != nil {
+		return
+	}
+	applied, err := m.applied(db)
+	if err != nil {
+		return
+	}
+
+	for _, migration := range m.listMigration {
+		status := Status{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied, status.AppliedAt = true, record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		res = append(res, status)
+	}
+	for _, record := range applied {
+		res = append(res, Status{
+			Version:     record.Version,
+			Description: record.Description,
+			Applied:     true,
+			AppliedAt:   record.AppliedAt,
+			Missing:     true,
+		})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+	return
+}
@@ -0,0 +1,28 @@
+package fmongo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ? Depend on Repository instead of *MongoDbUtil to be able to swap in NewMemoryMongoDbUtil on unit test.
+type Repository interface {
+	BaseFindOne(filter bson.M, pointerDecodeTo interface{}) error
+	FindOne(key, value string, pointerDecodeTo interface{}) error
+	BaseFind(filter bson.M, findOptions options.FindOptions, pointerDecodeTo interface{}) error
+	FindWrapError(filter bson.M, request interface{}, pointerDecodeTo interface{}) (*PaginationResponse, error)
+	Find(filter bson.M, request interface{}, pointerDecodeTo interface{}) (*PaginationResponse, string)
+	UpsertAndGetId(isUpdate bool, ptrParam interface{}) (string, error)
+	Upsert(isUpdate bool, ptrParam interface{}) error
+	BaseUpdateOne(filter, update bson.M)
+	DeleteOne(key, value string) error
+	Delete(filter bson.M) string
+	GetAggregate(groupStage mongo.Pipeline) []bson.M
+	CheckDuplicate(id string, listFilterOr []bson.M) error
+}
+
+var (
+	_ Repository = (*MongoDbUtil)(nil)
+	_ Repository = (*MemoryMongoDbUtil)(nil)
+)
@@ -0,0 +1,217 @@
+package fmongo
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+)
+
+type TimeSeries_Granularity int
+
+const (
+	TimeSeries_Granularity_Seconds TimeSeries_Granularity = iota
+	TimeSeries_Granularity_Minutes
+	TimeSeries_Granularity_Hours
+)
+
+func (index TimeSeries_Granularity) String() string {
+	return []string{
+		"seconds",
+		"minutes",
+		"hours",
+	}[index]
+}
+
+type TimeSeries_Unit int
+
+const (
+	TimeSeries_Unit_Minute TimeSeries_Unit = iota
+	TimeSeries_Unit_Hour
+	TimeSeries_Unit_Day
+	TimeSeries_Unit_Week
+	TimeSeries_Unit_Month
+)
+
+func (index TimeSeries_Unit) String() string {
+	return []string{
+		"minute",
+		"hour",
+		"day",
+		"week",
+		"month",
+	}[index]
+}
+
+type TimeSeriesOption struct {
+	TimeField   string //? Required, the field must be a date (time.Time)
+	MetaField   string
+	Granularity TimeSeries_Granularity
+	Expire      time.Duration //? 0 = never expire
+}
+
+type TimeSeriesQuery struct {
+	TimeField  string
+	ValueField string
+	MetaField  string //? Optional, when set each bucket is also split by the meta value
+	Unit       TimeSeries_Unit
+	BinSize    int64  //? e.g. Unit hour + BinSize 6 = 6 hours bucket. Default 1
+	Timezone   string //? e.g. "Asia/Jakarta". Default UTC
+	Start      time.Time
+	End        time.Time
+	Filter     bson.M
+}
+
+type TimeSeriesPoint struct {
+	Time  time.Time `bson:"time" json:"time"`
+	Meta  any       `bson:"meta,omitempty" json:"meta,omitempty"`
+	Min   float64   `bson:"min" json:"min"`
+	Max   float64   `bson:"max" json:"max"`
+	Avg   float64   `bson:"avg" json:"avg"`
+	Sum   float64   `bson:"sum" json:"sum"`
+	Count int64     `bson:"count" json:"count"`
+}
+
+// ? Collection name is taken from CollectionName. Need mongo >= 5.0
+func (umongo *MongoDbUtil) CreateTimeSeriesIfNotExists(opt TimeSeriesOption) (err error) {
+	if opt.TimeField == "" {
+		return errors.New("time field can't empty")
+	}
+
+	client, err := umongo.connect()
+	if err != nil {
+		return
+	}
+	defer umongo.Disconnect(client)
+	db := client.Database(umongo.DbName)
+	if listCollectionName, err := db.ListCollectionNames(umongo.Ctx, bson.M{}, &options.ListCollectionsOptions{}); err != nil {
+		log.Println(err)
+		return err
+	} else {
+		if slices.Contains(listCollectionName, umongo.CollectionName) {
+			return nil
+		}
+	}
+
+	timeSeriesOption := options.TimeSeries().
+		SetTimeField(opt.TimeField).
+		SetGranularity(opt.Granularity.String())
+	if opt.MetaField != "" {
+		timeSeriesOption.SetMetaField(opt.MetaField)
+	}
+	createOption := options.CreateCollection().SetTimeSeriesOptions(timeSeriesOption)
+	if opt.Expire > 0 {
+		createOption.SetExpireAfterSeconds(int64(opt.Expire.Seconds()))
+	}
+
+	if err := db.CreateCollection(umongo.Ctx, umongo.CollectionName, createOption); err != nil {
+		log.Println(umongo.CollectionName, err)
+		return err
+	}
+
+	log.Printf("%s (time series) created\n", umongo.CollectionName)
+	return
+}
+
+// ? listMeasurement must be a slice, e.g. []SensorMetric. Insert is unordered, one bad document doesn't stop the others.
+func (umongo *MongoDbUtil) InsertMeasurements(listMeasurement any) (insertedCount int, err error) {
+	asReflect := reflect.ValueOf(listMeasurement)
+	if asReflect.Kind() != reflect.Slice {
+		err = errors.New("listMeasurement is not slice")
+		return
+	}
+	if asReflect.Len() == 0 {
+		return
+	}
+	listDoc := make([]any, asReflect.Len())
+	for i := range listDoc {
+		listDoc[i] = asReflect.Index(i).Interface()
+	}
+
+	client, err := umongo.connect()
+	if err != nil {
+		return
+	}
+	defer umongo.Disconnect(client)
+	col := client.Database(umongo.DbName).Collection(umongo.CollectionName, umongo.defaultCollectionOption())
+
+	insertRes, err := col.InsertMany(umongo.Ctx, listDoc, options.InsertMany().SetOrdered(false))
+	if insertRes != nil {
+		insertedCount = len(insertRes.InsertedIDs)
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	return
+}
+
+func (umongo *MongoDbUtil) GetTimeSeries(query TimeSeriesQuery) (res []TimeSeriesPoint, err error) {
+	if query.TimeField == "" || query.ValueField == "" {
+		err = errors.New("time field and value field can't empty")
+		return
+	}
+	if query.BinSize <= 0 {
+		query.BinSize = 1
+	}
+	if query.Timezone == "" {
+		query.Timezone = "UTC"
+	}
+
+	match := bson.M{}
+	for key, value := range query.Filter {
+		match[key] = value
+	}
+	timeRange := bson.M{}
+	if !query.Start.IsZero() {
+		timeRange["$gte"] = query.Start
+	}
+	if !query.End.IsZero() {
+		timeRange["$lt"] = query.End
+	}
+	if len(timeRange) > 0 {
+		match[query.TimeField] = timeRange
+	}
+
+	groupId := bson.M{"time": bson.M{"$dateTrunc": bson.M{
+		"date":     "$" + query.TimeField,
+		"unit":     query.Unit.String(),
+		"binSize":  query.BinSize,
+		"timezone": query.Timezone,
+	}}}
+	if query.MetaField != "" {
+		groupId["meta"] = "$" + query.MetaField
+	}
+	value := "$" + query.ValueField
+
+	listDoc, err := umongo.BaseGetAggregate(mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   groupId,
+			"min":   bson.M{"$min": value},
+			"max":   bson.M{"$max": value},
+			"avg":   bson.M{"$avg": value},
+			"sum":   bson.M{"$sum": value},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id": 0, "time": "$_id.time", "meta": "$_id.meta",
+			"min": 1, "max": 1, "avg": 1, "sum": 1, "count": 1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: 1}, {Key: "meta", Value: 1}}}},
+	})
+	if err != nil {
+		return
+	}
+
+	if err = decodeAll(listDoc, &res); err != nil {
+		log.Println(err)
+		return
+	}
+	return
+}
@@ -0,0 +1,148 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+type KeyValue[T any] struct {
+	Key   string //? Without the key prefix
+	Value T
+}
+
+func (c *Cache[T]) unprefixKey(fullKey string) string {
+	return strings.TrimPrefix(fullKey, c.redis.prefixKey(""))
+}
+
+// ? found false for a missing or a negative (GetOrLoad) entry.
+func (c *Cache[T]) decodeCmd(fullKey string, cmd *redis.StringCmd) (value T, found bool, err error) {
+	raw, err := cmd.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return value, false, nil
+	}
+	if err != nil {
+		return
+	}
+
+	cached := decodeEnvelope(raw)
+	if cached.negative {
+		return
+	}
+	if err = c.codec.Unmarshal(cached.payload, &value); err != nil {
+		log.Println(fullKey, aurora.Red(err))
+		err = fmt.Errorf("decode %s: %w", fullKey, err)
+		return
+	}
+	return value, true, nil
+}
+
+// ? Missing keys are not in the result. A value that can't be decoded is skipped and its error returned
+// ? together with the rest of the result.
+func (c *Cache[T]) GetMany(ctx context.Context, listKey []string) (res map[string]T, err error) {
+	defer c.startSpan(ctx, "Cache.GetMany")()
+
+	listFullKey := make([]string, len(listKey))
+	for i, key := range listKey {
+		listFullKey[i] = c.redis.prefixKey(key)
+	}
+	listCmd, err := c.redis.getManyRaw(ctx, listFullKey)
+	if err != nil {
+		return
+	}
+
+	res = make(map[string]T, len(listKey))
+	var listErr []error
+	for i, cmd := range listCmd {
+		value, found, errDecode := c.decodeCmd(listFullKey[i], cmd)
+		if errDecode != nil {
+			listErr = append(listErr, errDecode)
+			continue
+		}
+		if found {
+			res[listKey[i]] = value
+		}
+	}
+	return res, errors.Join(listErr...)
+}
+
+// ? Pipelined SET in chunks, ttl 0 = no expiration. Not atomic: on error part of the values may be written.
+func (c *Cache[T]) SetMany(ctx context.Context, values map[string]T, ttl time.Duration) (err error) {
+	defer c.startSpan(ctx, "Cache.SetMany")()
+
+	listFullKey, listRaw := make([]string, 0, len(values)), make([][]byte, 0, len(values))
+	for key, value := range values {
+		raw, err := c.codec.Marshal(value)
+		if err != nil {
+			log.Println(aurora.Red(err))
+			return fmt.Errorf("encode %s: %w", key, err)
+		}
+		listFullKey, listRaw = append(listFullKey, c.redis.prefixKey(key)), append(listRaw, raw)
+	}
+
+	rdb := c.redis.open()
+	for start := 0; start < len(listFullKey); start += batchSize {
+		end := min(start+batchSize, len(listFullKey))
+		log.Println(aurora.BrightRed(fmt.Sprintf("redis.set: %d key", end-start)))
+		if _, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := start; i < end; i++ {
+				pipe.Set(ctx, listFullKey[i], listRaw[i], ttl)
+			}
+			return nil
+		}); err != nil {
+			log.Println(aurora.Red(err))
+			return
+		}
+	}
+	return
+}
+
+var errStopScan = errors.New("stop scan")
+
+// ? Stream the key/value matching pattern (SCAN + pipelined GET per batch), nothing is collected in memory.
+// ? The key prefix is applied to the pattern and removed from the key. Stop on the first connection error,
+// ? a value that can't be decoded is yielded with its error and the scan continue.
+// ? e.g. for kv, err := range cache.ScanValues(ctx, "outlet:*") {...}
+func (c *Cache[T]) ScanValues(ctx context.Context, pattern string) iter.Seq2[KeyValue[T], error] {
+	return func(yield func(KeyValue[T], error) bool) {
+		batch := make([]string, 0, batchSize)
+		flush := func() error {
+			listCmd, err := c.redis.getManyRaw(ctx, batch)
+			if err != nil {
+				return err
+			}
+			for i, cmd := range listCmd {
+				value, found, err := c.decodeCmd(batch[i], cmd)
+				if !found && err == nil { //? Deleted or expired since the SCAN
+					continue
+				}
+				if !yield(KeyValue[T]{Key: c.unprefixKey(batch[i]), Value: value}, err) {
+					return errStopScan
+				}
+			}
+			batch = batch[:0]
+			return nil
+		}
+
+		err := c.redis.scan(ctx, c.redis.prefixKey(pattern), batchSize, func(fullKey string) error {
+			if batch = append(batch, fullKey); len(batch) >= batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil && len(batch) > 0 {
+			err = flush()
+		}
+		if err != nil && !errors.Is(err, errStopScan) {
+			log.Println(aurora.Red(err))
+			yield(KeyValue[T]{}, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+// ? Typed cache on top of Redis, e.g. fredis.NewCache[[]Outlet](rds, fredis.GzipJSONCodec)
+type Cache[T any] struct {
+	redis      *Redis
+	codec      Codec
+	loadOption LoadOption
+}
+
+// ? codec nil = JSONCodec
+func NewCache[T any](r *Redis, codec Codec) *Cache[T] {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return &Cache[T]{redis: r, codec: codec}
+}
+
+// ? Stampede protection of GetOrLoad, see LoadOption.
+func (c *Cache[T]) SetLoadOption(loadOption LoadOption) *Cache[T] {
+	c.loadOption = loadOption
+	return c
+}
+
+func (c *Cache[T]) startSpan(ctx context.Context, operation string) func() {
+	if !c.redis.useSentry {
+		return func() {}
+	}
+	span := sentry.StartSpan(ctx, operation)
+	return span.Finish
+}
+
+// ? found=false & err=nil is a cache miss, err is a connection or decode error.
+func (c *Cache[T]) Get(ctx context.Context, key string) (value T, found bool, err error) {
+	defer c.startSpan(ctx, "Cache.Get")()
+
+	key = c.redis.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.get: key=%s", aurora.BrightBlue(key))))
+	raw, err := c.redis.open().Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		err = nil
+		return
+	}
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+
+	cached := decodeEnvelope(raw) //? Written by GetOrLoad
+	if cached.negative {
+		return
+	}
+	if err = c.codec.Unmarshal(cached.payload, &value); err != nil {
+		log.Println(key, aurora.Red(err))
+		err = fmt.Errorf("decode %s: %w", key, err)
+		return
+	}
+	found = true
+	return
+}
+
+// ? ttl 0 = no expiration, tags (optional) allow to delete the key with InvalidateTags.
+func (c *Cache[T]) Set(ctx context.Context, key string, value T, ttl time.Duration, tags ...string) (err error) {
+	defer c.startSpan(ctx, "Cache.Set")()
+
+	raw, err := c.codec.Marshal(value)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return fmt.Errorf("encode %s: %w", key, err)
+	}
+
+	key = c.redis.prefixKey(key)
+	if len(tags) > 0 {
+		return c.redis.setWithTags(ctx, key, raw, ttl, tags, nil)
+	}
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.set: key=%s", key)))
+	if err = c.redis.open().Set(ctx, key, raw, ttl).Err(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (c *Cache[T]) Delete(ctx context.Context, listKey ...string) (err error) {
+	_, err = c.redis.DeleteMany(ctx, listKey...)
+	return
+}
+
+// ? Cache aside with stampede protection: only one loader run per key across the cluster,
+// ? the others wait for it or get the stale value (LoadOption.StaleTTL).
+// ? When Redis is down the loader result is still returned.
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) (value T, err error) {
+	defer c.startSpan(ctx, "Cache.GetOrLoad")()
+
+	payload, err := c.redis.getOrLoadBytes(ctx, key, ttl, c.loadOption, func(ctx context.Context) ([]byte, error) {
+		value, err := loader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return c.codec.Marshal(value)
+	})
+	if err != nil {
+		return
+	}
+
+	if err = c.codec.Unmarshal(payload, &value); err != nil {
+		log.Println(key, aurora.Red(err))
+		err = fmt.Errorf("decode %s: %w", key, err)
+		return
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package fredis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, ptrDecodeTo any) error
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	GzipJSONCodec Codec = gzipCodec{codec: jsonCodec{}} //? For big payload, trade CPU for memory
+	//? encoding/gob, not msgpack: compact but only another Go service decoding the same type can read it.
+	//? Keep JSONCodec for a key shared with other languages.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, ptrDecodeTo any) error {
+	return json.Unmarshal(data, ptrDecodeTo)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) (res []byte, err error) {
+	var buffer bytes.Buffer
+	if err = gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, ptrDecodeTo any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(ptrDecodeTo)
+}
+
+type gzipCodec struct {
+	codec Codec
+}
+
+// ? Wrap any codec with gzip compression.
+func NewGzipCodec(codec Codec) Codec {
+	return gzipCodec{codec: codec}
+}
+
+func (c gzipCodec) Marshal(v any) (res []byte, err error) {
+	raw, err := c.codec.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err = writer.Write(raw); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c gzipCodec) Unmarshal(data []byte, ptrDecodeTo any) (err error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return
+	}
+	return c.codec.Unmarshal(raw, ptrDecodeTo)
+}
@@ -0,0 +1,34 @@
+package fredis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+// ? The ttl is only set when the key has none, so the window start on the first write.
+var incrScript = redis.NewScript(`
+local res = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return res`)
+
+// ? ttl 0 = no expiration. e.g. daily quota: rds.Incr(ctx, "quota:"+userID, 24*time.Hour)
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return r.IncrBy(ctx, key, 1, ttl)
+}
+
+func (r *Redis) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (res int64, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.incrby: key=%s n=%d", key, n)))
+	if res, err = incrScript.Run(ctx, r.open(), []string{key}, n, ttl.Milliseconds()).Int64(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
@@ -3,16 +3,17 @@ package fredis
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -21,7 +22,8 @@ import (
 )
 
 type Redis struct {
-	URI            string
+	URI            string //? host:port, or redis:// / rediss:// url
+	Username       string //? ACL user, empty = default
 	Password       string
 	DB             int
 	ctx            context.Context
@@ -29,6 +31,15 @@ type Redis struct {
 	keyPrefix      string
 	CustomTimeout  int
 	CustomProtocol int
+
+	SentinelMasterName string //? Sentinel mode, URI is ignored
+	SentinelAddrs      []string
+	SentinelUsername   string
+	SentinelPassword   string
+	ClusterAddrs       []string   //? Cluster mode (seed nodes), URI is ignored
+	TLS                *TLSOption //? Also enabled by a rediss:// URI
+
+	shared *sharedClient
 }
 
 const (
@@ -51,7 +62,11 @@ func NewRedisByOption(db string, opt Redis) (res *Redis, err error) {
 	}
 
 	opt.DB = int(dbAsNum)
-	opt.ctx = context.Background()
+	opt.ctx, opt.shared = context.Background(), nil
+	if _, err = opt.universalOption(); err != nil { //? Invalid url or TLS files
+		log.Println(aurora.Red(err))
+		return
+	}
 	res = &opt
 
 	res.Get("", nil) //? Initialize the first connection.
@@ -86,20 +101,27 @@ func NewRedisWithPassword(uri, db, password string) (res *Redis, err error) {
 }
 
 func NewRedisCacheConnection() (res *Redis, err error) {
-	return NewRedisWithPassword(os.Getenv("REDIS_SERVER"), os.Getenv("REDIS_DB_CACHE"), os.Getenv("REDIS_AUTH"))
+	return NewRedisUseEnv(os.Getenv("REDIS_DB_CACHE"))
 }
 
 func _NewRedis() (res *Redis, err error) {
 	return NewRedis("localhost:6379", "0")
 }
 
+type sharedClient struct {
+	key      string
+	client   redis.UniversalClient
+	refCount int //? Number of Redis using the client
+	closed   bool
+}
+
 var (
-	listClient map[any]*redis.Client
+	listClient map[string]*sharedClient //? By connectionKey, every Redis with the same connection share one pool
 	mutex      sync.RWMutex
 )
 
 func init() {
-	listClient = make(map[any]*redis.Client)
+	listClient = make(map[string]*sharedClient)
 }
 
 func (r *Redis) SetKeyPrefix(keyPrefix string) *Redis {
@@ -107,39 +129,42 @@ func (r *Redis) SetKeyPrefix(keyPrefix string) *Redis {
 	return r
 }
 
+func (r *Redis) prefixKey(key string) string {
+	if r.keyPrefix != "" {
+		return r.keyPrefix + FOLDER_DELIMITER + key
+	}
+	return key
+}
+
 func (o *Redis) SetSentryCtx(ctx context.Context) *Redis {
 	o.ctx, o.useSentry = ctx, true
 	return o
 }
 
-func (o *Redis) setClient(newClient *redis.Client) {
-	mutex.Lock()
-	listClient[o] = newClient
-	mutex.Unlock()
-
-	go func() {
-		sc := make(chan os.Signal, 1)
-		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-		<-sc
-		log.Println("Closing client.")
-		listClient[o].Close()
-		os.Exit(0)
-	}()
+// ? Everything that changes the connection, the password is hashed with the rest.
+func (o *Redis) connectionKey() string {
+	tlsKey := "-"
+	if o.TLS != nil {
+		tlsKey = fmt.Sprintf("%q", []any{o.TLS.CAFile, o.TLS.CertFile, o.TLS.KeyFile, o.TLS.ServerName,
+			o.TLS.InsecureSkipVerify, fmt.Sprintf("%p", o.TLS.Config)})
+	}
+	raw := fmt.Sprintf("%q", []any{o.URI, o.Username, o.Password, o.DB, o.CustomProtocol, o.CustomTimeout,
+		o.SentinelMasterName, o.SentinelAddrs, o.SentinelUsername, o.SentinelPassword, o.ClusterAddrs, tlsKey})
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
 }
 
-func (o *Redis) getClient() (res *redis.Client) {
+func (o *Redis) getClient() (res redis.UniversalClient) {
 	mutex.RLock()
-	client := listClient[o]
-	mutex.RUnlock()
+	defer mutex.RUnlock()
 
-	if client != nil {
-		res = client
-		return res
+	if o.shared != nil && !o.shared.closed {
+		res = o.shared.client
 	}
 	return
 }
 
-func (o *Redis) open() (rdb *redis.Client) {
+func (o *Redis) open() (rdb redis.UniversalClient) {
 	if o.useSentry {
 		span := sentry.StartSpan(o.ctx, "Redis.open")
 		defer span.Finish()
@@ -148,65 +173,158 @@ func (o *Redis) open() (rdb *redis.Client) {
 	if rdb = o.getClient(); rdb != nil {
 		return
 	}
-	timeout := 1
-	if o.CustomTimeout > int(0) {
-		timeout = o.CustomTimeout
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if o.shared != nil && !o.shared.closed { //? Opened by another goroutine meanwhile
+		return o.shared.client
 	}
-	connectionOption := &redis.Options{
-		Addr:         o.URI,
-		DB:           o.DB,
-		PoolSize:     100,
-		DialTimeout:  time.Duration(timeout) * time.Second,
-		ReadTimeout:  time.Duration(timeout) * time.Second,
-		WriteTimeout: time.Duration(timeout) * time.Second,
-		Protocol:     2,
+
+	key := o.connectionKey()
+	shared := listClient[key]
+	if shared == nil {
+		shared = &sharedClient{key: key, client: o.newUniversalClient()}
+		listClient[key] = shared
+		log.Println(aurora.BrightRed("redis.open"))
 	}
-	if o.CustomProtocol > 0 { //? example : NewRedisByOption("1", Redis{CustomProtocol: 3})
-		connectionOption.Protocol = o.CustomProtocol
+	shared.refCount++
+	o.shared = shared
+	return shared.client
+}
+
+// ? Release the connection of this Redis, the pool is closed when the last Redis sharing it is closed.
+// ? Using the Redis after Close open the connection again.
+func (o *Redis) Close() (err error) {
+	mutex.Lock()
+	shared := o.shared
+	o.shared = nil
+	if shared == nil || shared.closed {
+		mutex.Unlock()
+		return
+	}
+	if shared.refCount--; shared.refCount > 0 {
+		mutex.Unlock()
+		return
 	}
-	if o.Password != "" {
-		connectionOption.Password = o.Password
+	shared.closed = true
+	delete(listClient, shared.key)
+	mutex.Unlock()
+
+	log.Println(aurora.BrightRed("redis.close"))
+	if err = shared.client.Close(); err != nil {
+		log.Println(aurora.Red(err))
+		return
 	}
-	rdb = redis.NewClient(connectionOption)
-	o.setClient(rdb)
-	log.Println(aurora.BrightRed("redis.open"))
 	return
 }
 
-func (r *Redis) GetClient() (redis *redis.Client) {
+// ? Close every client of the process, for graceful shutdown (the library doesn't listen to signals).
+// ? e.g. defer fredis.CloseAll(context.Background())
+func CloseAll(ctx context.Context) (err error) {
+	mutex.Lock()
+	listShared := listClient
+	listClient = make(map[string]*sharedClient)
+	for _, shared := range listShared {
+		shared.closed = true
+	}
+	mutex.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		var listErr []error
+		for _, shared := range listShared {
+			if err := shared.client.Close(); err != nil {
+				log.Println(aurora.Red(err))
+				listErr = append(listErr, err)
+			}
+		}
+		done <- errors.Join(listErr...)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-done:
+		log.Println(aurora.BrightRed(fmt.Sprintf("redis.close: %d client", len(listShared))))
+		return
+	}
+}
+
+// ? Deprecated: use GetUniversalClient, it works in every mode.
+// ? Breaking change: in cluster mode (ClusterAddrs set) there is no *redis.Client, nil is returned
+// ? and logged. Single node and sentinel still return the client as before.
+func (r *Redis) GetClient() (res *redis.Client) {
+	rdb := r.GetUniversalClient()
+	if rdb == nil {
+		return nil
+	}
+
+	res, ok := rdb.(*redis.Client)
+	if !ok {
+		log.Println(aurora.Red("redis: GetClient is not available in cluster mode, use GetUniversalClient"))
+	}
+	return
+}
+
+// ? *redis.Client for single node / sentinel, *redis.ClusterClient for cluster.
+func (r *Redis) GetUniversalClient() redis.UniversalClient {
 	if r == nil {
 		log.Println("r nil")
 		return nil
 	}
-
-	rdb := r.open()
-	return rdb
+	return r.open()
 }
 
-func (r *Redis) Set(key string, value any, expireDate time.Duration) (err error) {
+// ? tags (optional) allow to delete the key with InvalidateTags.
+func (r *Redis) Set(key string, value any, expireDate time.Duration, tags ...string) (err error) {
 	rdb := r.open()
 	// defer rdb.Close()
 
-	if r.keyPrefix != "" {
-		key = r.keyPrefix + FOLDER_DELIMITER + key
+	key = r.prefixKey(key)
+	if value, err = encodeValue(value); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+	if len(tags) > 0 {
+		return r.setWithTags(r.ctx, key, value, expireDate, tags, nil)
 	}
 	log.Println(aurora.BrightRed(fmt.Sprintf("redis.set: key=%s", key)))
 	status := rdb.Set(r.ctx, key, value, expireDate)
 	if err = status.Err(); err != nil {
-		if strings.Contains(err.Error(), "(implement encoding.BinaryMarshaler)") {
-			err = r.MarshalValueThenSet(key, value, expireDate)
-		} else {
-			log.Println(aurora.Red(err))
-			return
-		}
+		log.Println(aurora.Red(err))
+		return
 	}
 	return
 }
 
+// ? go-redis can't write struct/map/slice, they are stored as json.
+func encodeValue(value any) (any, error) {
+	if isNativeValue(value) {
+		return value, nil
+	}
+	return json.Marshal(value)
+}
+
+func isNativeValue(value any) bool {
+	switch value.(type) {
+	case nil, string, *string, []byte, bool, *bool, time.Time, time.Duration, encoding.BinaryMarshaler,
+		int, *int, int8, *int8, int16, *int16, int32, *int32, int64, *int64,
+		uint, *uint, uint8, *uint8, uint16, *uint16, uint32, *uint32, uint64, *uint64,
+		float32, *float32, float64, *float64:
+		return true
+	}
+	return false
+}
+
 func BuildKey(key ...string) string {
 	return strings.Join(key, FOLDER_DELIMITER)
 }
 
+// ? Same as BuildKey but with the key prefix, for command sent through GetUniversalClient.
+func (r *Redis) BuildKey(key ...string) string {
+	return r.prefixKey(BuildKey(key...))
+}
+
 func (o *Redis) MarshalValueThenSet(key string, value any, expireDate time.Duration) (err error) {
 	asJson, err := json.Marshal(value)
 	if err != nil {
@@ -221,9 +339,7 @@ func (r *Redis) Get(key string, ptrDecodeTo any) (found bool) {
 	rdb := r.open()
 	// defer rdb.Close()
 
-	if r.keyPrefix != "" {
-		key = r.keyPrefix + FOLDER_DELIMITER + key
-	}
+	key = r.prefixKey(key)
 	log.Println(aurora.BrightRed(fmt.Sprintf("\nredis.get: key=%s", aurora.BrightBlue(key))))
 	status := rdb.Get(r.ctx, key) //? Only the first execution can take > 150ms time, after that only < 20ms
 	if err := status.Err(); err != nil {
@@ -237,21 +353,26 @@ func (r *Redis) Get(key string, ptrDecodeTo any) (found bool) {
 		return
 	}
 
+	if err = decodeValue(res, ptrDecodeTo); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	found = true
+	return
+}
+
+func decodeValue(raw string, ptrDecodeTo any) (err error) {
 	switch target := ptrDecodeTo.(type) {
 	case *string:
-		*target = res
+		*target = raw
 	case *[]byte:
-		*target = []byte(res)
+		*target = []byte(raw)
 	case *bytes.Buffer:
-		*target = *bytes.NewBuffer([]byte(res))
+		*target = *bytes.NewBuffer([]byte(raw))
 	default:
-		if err = json.Unmarshal([]byte(res), ptrDecodeTo); err != nil {
-			log.Println(aurora.Red(err))
-			return
-		}
+		err = json.Unmarshal([]byte(raw), ptrDecodeTo)
 	}
-
-	found = true
 	return
 }
 
@@ -260,37 +381,27 @@ func (r *Redis) BaseCountAndGetKey(keyPattern string, getWithValue bool) (res in
 		span := sentry.StartSpan(r.ctx, "Redis.CountAndGetKey")
 		defer span.Finish()
 	}
-	rdb := r.open()
-	// defer rdb.Close()
-
-	if r.keyPrefix != "" {
-		keyPattern = r.keyPrefix + FOLDER_DELIMITER + keyPattern
-	}
-	iter := rdb.Scan(r.ctx, 0, keyPattern, 0).Iterator()
-	for iter.Next(r.ctx) {
-		listKey = append(listKey, iter.Val())
-	}
-	if err := iter.Err(); err != nil {
+	keyPattern = r.prefixKey(keyPattern)
+	if err := r.scan(r.ctx, keyPattern, 0, func(key string) error {
+		listKey = append(listKey, key)
+		return nil
+	}); err != nil {
 		log.Println(err)
 		return
 	}
 
-	if getWithValue {
-		mgetRes := rdb.MGet(r.ctx, listKey...)
-		listKey = []string{}
-		if err := mgetRes.Err(); err != nil {
+	if getWithValue { //? listValue[i] is the value of listKey[i], nil when the key is gone meanwhile
+		listCmd, err := r.getManyRaw(r.ctx, listKey)
+		if err != nil {
 			log.Println(err)
 			return
 		}
-		for _, each := range mgetRes.Args() {
-			asStr, _ := each.(string)
-			if asStr == "mget" {
-				continue
+		listValue = make([]any, len(listCmd))
+		for i, cmd := range listCmd {
+			if cmd.Err() == nil {
+				listValue[i] = cmd.Val()
 			}
-
-			listKey = append(listKey, asStr)
 		}
-		listValue = append(listValue, mgetRes.Val()...)
 	}
 
 	res = len(listKey)
@@ -313,24 +424,154 @@ func (o *Redis) Count(keyPattern string) (res int) {
 }
 
 func (r *Redis) Delete(key string) (err error) {
-	err = r.Set(key, "", 1*time.Millisecond)
+	_, err = r.DeleteMany(r.ctx, key)
+	return
+}
+
+// ? Chunk size of the multi keys commands and pipelines.
+const batchSize = 500
+
+// ? UNLINK free the memory in background, chunked to keep each command small.
+func (r *Redis) DeleteMany(ctx context.Context, listKey ...string) (deleted int64, err error) {
+	rdb := r.open()
+
+	for start := 0; start < len(listKey); start += batchSize {
+		batch := make([]string, 0, batchSize)
+		for _, key := range listKey[start:min(start+batchSize, len(listKey))] {
+			batch = append(batch, r.prefixKey(key))
+		}
+		log.Println(aurora.BrightRed(fmt.Sprintf("redis.unlink: key=%s", strings.Join(batch, ","))))
+
+		var count int64
+		if count, err = unlink(ctx, rdb, batch); err != nil {
+			log.Println(aurora.Red(err))
+			return
+		}
+		deleted += count
+	}
+	return
+}
+
+// ? Use SCAN + UNLINK instead of KEYS, so Redis is never blocked. The key prefix is applied to the pattern.
+// ? onProgress (optional) is called after every deleted batch with the total deleted so far.
+func (r *Redis) DeleteByPatternCtx(ctx context.Context, pattern string, onProgress func(deleted int64)) (deleted int64, err error) {
+	deleted, _, err = r.baseDeleteByPattern(ctx, pattern, false, onProgress)
 	return
 }
 
 func (r *Redis) DeleteByPattern(pattern string) (listDeletedKey []string, err error) {
+	_, listDeletedKey, err = r.baseDeleteByPattern(r.ctx, pattern, true, nil)
+	return
+}
+
+func (r *Redis) baseDeleteByPattern(ctx context.Context, pattern string, collectKey bool, onProgress func(deleted int64)) (deleted int64, listDeletedKey []string, err error) {
+	if r.useSentry {
+		span := sentry.StartSpan(ctx, "Redis.DeleteByPattern")
+		defer span.Finish()
+	}
 	rdb := r.open()
+	pattern = r.prefixKey(pattern)
 
-	listDeletedKey, err = rdb.Keys(r.ctx, pattern).Result()
-	if err != nil {
-		log.Println(err)
+	batch := make([]string, 0, batchSize)
+	flush := func() (err error) {
+		if len(batch) == 0 {
+			return
+		}
+		count, err := unlink(ctx, rdb, batch)
+		if err != nil {
+			log.Println(aurora.Red(err))
+			return
+		}
+		deleted += count
+		if collectKey {
+			listDeletedKey = append(listDeletedKey, batch...)
+		}
+		if onProgress != nil {
+			onProgress(deleted)
+		}
+		batch = batch[:0]
 		return
 	}
 
-	err = rdb.Del(r.ctx, listDeletedKey...).Err()
+	if err = r.scan(ctx, pattern, batchSize, func(key string) error {
+		if batch = append(batch, key); len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	}); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+	if err = flush(); err != nil {
+		return
+	}
+	fmt.Println("Deleted keys:", deleted)
+	return
+}
+
+// ? SCAN only walk one node, in cluster mode every master is scanned (one at a time, fn is not called concurrently).
+func (r *Redis) scan(ctx context.Context, pattern string, count int64, fn func(key string) error) (err error) {
+	scanNode := func(ctx context.Context, node redis.UniversalClient) error {
+		iter := node.Scan(ctx, 0, pattern, count).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	rdb := r.open()
+	cluster, isCluster := rdb.(*redis.ClusterClient)
+	if !isCluster {
+		return scanNode(ctx, rdb)
+	}
+	var scanMutex sync.Mutex
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		scanMutex.Lock()
+		defer scanMutex.Unlock()
+		return scanNode(ctx, node)
+	})
+}
+
+// ? Multi keys UNLINK fail with CROSSSLOT in cluster mode, there it's one UNLINK per key in a pipeline.
+func unlink(ctx context.Context, rdb redis.UniversalClient, listKey []string) (deleted int64, err error) {
+	if _, isCluster := rdb.(*redis.ClusterClient); !isCluster {
+		return rdb.Unlink(ctx, listKey...).Result()
+	}
+
+	listCmd, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range listKey {
+			pipe.Unlink(ctx, key)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Println(err)
 		return
 	}
-	fmt.Println("Deleted keys:", len(listDeletedKey))
+	for _, cmd := range listCmd {
+		deleted += cmd.(*redis.IntCmd).Val()
+	}
 	return
 }
+
+// ? GET in pipelined chunks (also works in cluster mode, unlike MGET). Keys are already prefixed,
+// ? a missing key has redis.Nil as error in its cmd.
+func (r *Redis) getManyRaw(ctx context.Context, listFullKey []string) (res []*redis.StringCmd, err error) {
+	rdb := r.open()
+	res = make([]*redis.StringCmd, 0, len(listFullKey))
+	for start := 0; start < len(listFullKey); start += batchSize {
+		batch := listFullKey[start:min(start+batchSize, len(listFullKey))]
+		log.Println(aurora.BrightRed(fmt.Sprintf("redis.get: %d key", len(batch))))
+		if _, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range batch {
+				res = append(res, pipe.Get(ctx, key))
+			}
+			return nil
+		}); err != nil && !errors.Is(err, redis.Nil) {
+			log.Println(aurora.Red(err))
+			return
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,246 @@
+package fredis
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+// ? Same tag as go-redis, e.g. `redis:"name"` / `redis:"name,omitempty"`, a field without tag is skipped.
+const HASH_TAG = "redis"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ? time.Time is stored as RFC3339, struct/map/slice as json, the rest as go-redis write them.
+func encodeHashValue(value any) (any, error) {
+	asValue := reflect.ValueOf(value)
+	for asValue.Kind() == reflect.Pointer && !asValue.IsNil() {
+		asValue = asValue.Elem()
+	}
+	if asValue.IsValid() && asValue.Type() == timeType { //? Before encodeValue, *time.Time is a BinaryMarshaler
+		return asValue.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+	return encodeValue(value)
+}
+
+func decodeHashValue(field reflect.Value, raw string) (err error) {
+	if field.Type() == timeType {
+		asTime, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(asTime))
+		return nil
+	}
+	if unmarshaler, ok := field.Addr().Interface().(encoding.BinaryUnmarshaler); ok {
+		return unmarshaler.UnmarshalBinary([]byte(raw))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		asBool, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(asBool)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		asInt, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(asInt)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		asUint, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(asUint)
+	case reflect.Float32, reflect.Float64:
+		asFloat, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(asFloat)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Uint8 {
+			field.SetBytes([]byte(raw))
+			return nil
+		}
+		return json.Unmarshal([]byte(raw), field.Addr().Interface())
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err = decodeHashValue(elem.Elem(), raw); err != nil {
+			return
+		}
+		field.Set(elem)
+	default:
+		return json.Unmarshal([]byte(raw), field.Addr().Interface())
+	}
+	return
+}
+
+func parseHashTag(field reflect.StructField) (name string, omitEmpty bool) {
+	tag := field.Tag.Get(HASH_TAG)
+	if tag == "" || tag == "-" || !field.IsExported() {
+		return
+	}
+	name, option, _ := strings.Cut(tag, ",")
+	return name, option == "omitempty"
+}
+
+// ? value is a struct (or pointer to struct) with redis tags, or a map with string keys.
+func toHash(value any) (res map[string]any, err error) {
+	asValue := reflect.Indirect(reflect.ValueOf(value))
+	res = make(map[string]any)
+
+	switch asValue.Kind() {
+	case reflect.Map:
+		if asValue.Type().Key().Kind() != reflect.String {
+			return nil, errors.New("hash map key must be a string")
+		}
+		iter := asValue.MapRange()
+		for iter.Next() {
+			if res[iter.Key().String()], err = encodeHashValue(iter.Value().Interface()); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < asValue.NumField(); i++ {
+			name, omitEmpty := parseHashTag(asValue.Type().Field(i))
+			field := asValue.Field(i)
+			if name == "" || (omitEmpty && field.IsZero()) || (field.Kind() == reflect.Pointer && field.IsNil()) {
+				continue
+			}
+			if res[name], err = encodeHashValue(field.Interface()); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.New("hash value must be a struct or a map")
+	}
+	return
+}
+
+// ? ptrDecodeTo is a pointer to struct with redis tags, or *map[string]string.
+func fromHash(hash map[string]string, ptrDecodeTo any) (err error) {
+	if asMap, ok := ptrDecodeTo.(*map[string]string); ok {
+		*asMap = hash
+		return
+	}
+
+	asValue := reflect.ValueOf(ptrDecodeTo)
+	if asValue.Kind() != reflect.Pointer || asValue.IsNil() || asValue.Elem().Kind() != reflect.Struct {
+		return errors.New("ptrDecodeTo must be a pointer to struct or *map[string]string")
+	}
+	asValue = asValue.Elem()
+	for i := 0; i < asValue.NumField(); i++ {
+		name, _ := parseHashTag(asValue.Type().Field(i))
+		raw, found := hash[name]
+		if name == "" || !found {
+			continue
+		}
+		if err = decodeHashValue(asValue.Field(i), raw); err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return
+}
+
+// ? value is a struct with redis tags or a map, only the given fields are written. ttl 0 = keep the current ttl.
+// ? e.g. rds.HSet(ctx, "user:1", User{Name: "a"}, time.Hour)
+func (r *Redis) HSet(ctx context.Context, key string, value any, ttl time.Duration) (err error) {
+	hash, err := toHash(value)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.hset: key=%s", key)))
+	if _, err = r.open().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, hash)
+		if ttl > 0 {
+			pipe.Expire(ctx, key, ttl)
+		}
+		return nil
+	}); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) HGet(ctx context.Context, key, field string, ptrDecodeTo any) (found bool, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.hget: key=%s field=%s", aurora.BrightBlue(key), field)))
+	raw, err := r.open().HGet(ctx, key, field).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+
+	asValue := reflect.ValueOf(ptrDecodeTo)
+	if asValue.Kind() != reflect.Pointer || asValue.IsNil() {
+		return false, errors.New("ptrDecodeTo must be a pointer")
+	}
+	if err = decodeHashValue(asValue.Elem(), raw); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return true, nil
+}
+
+// ? found false when the key doesn't exist.
+func (r *Redis) HGetAll(ctx context.Context, key string, ptrDecodeTo any) (found bool, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.hgetall: key=%s", aurora.BrightBlue(key))))
+	hash, err := r.open().HGetAll(ctx, key).Result()
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	if len(hash) == 0 {
+		return
+	}
+
+	if err = fromHash(hash, ptrDecodeTo); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return true, nil
+}
+
+func (r *Redis) HIncrBy(ctx context.Context, key, field string, n int64) (res int64, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.hincrby: key=%s field=%s", key, field)))
+	if res, err = r.open().HIncrBy(ctx, key, field, n).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) HDel(ctx context.Context, key string, fields ...string) (deleted int64, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.hdel: key=%s field=%s", key, strings.Join(fields, ","))))
+	if deleted, err = r.open().HDel(ctx, key, fields...).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
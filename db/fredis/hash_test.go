@@ -0,0 +1,153 @@
+package fredis
+
+import (
+	"encoding"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type hashTestAddress struct {
+	City string `json:"city"`
+}
+
+type hashTestUser struct {
+	Name      string           `redis:"name"`
+	Age       int              `redis:"age"`
+	Active    bool             `redis:"active"`
+	Score     float64          `redis:"score"`
+	Nickname  *string          `redis:"nickname"`
+	Level     *int64           `redis:"level"`
+	CreatedAt time.Time        `redis:"createdAt"`
+	DeletedAt *time.Time       `redis:"deletedAt"`
+	Address   *hashTestAddress `redis:"address"`
+	Tags      []string         `redis:"tags"`
+	Note      string           `redis:"note,omitempty"`
+	Skipped   string
+}
+
+// ? The string go-redis send for an encoded value.
+func hashTestWire(t *testing.T, value any) string {
+	t.Helper()
+	switch asType := value.(type) {
+	case string:
+		return asType
+	case []byte:
+		return string(asType)
+	case bool:
+		if asType {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(asType, 'f', -1, 64)
+	case *string:
+		return *asType
+	case *int64:
+		return strconv.FormatInt(*asType, 10)
+	case int:
+		return strconv.Itoa(asType)
+	case encoding.BinaryMarshaler:
+		raw, err := asType.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	t.Fatalf("unexpected encoded type %T", value)
+	return ""
+}
+
+func hashTestRoundTrip(t *testing.T, value any, ptrDecodeTo any) map[string]string {
+	t.Helper()
+	hash, err := toHash(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire := make(map[string]string, len(hash))
+	for key, encoded := range hash {
+		wire[key] = hashTestWire(t, encoded)
+	}
+	if err = fromHash(wire, ptrDecodeTo); err != nil {
+		t.Fatal(err)
+	}
+	return wire
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	nickname, level := "al", int64(7)
+	createdAt := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.FixedZone("WIB", 7*3600))
+	deletedAt := createdAt.Add(time.Hour)
+
+	testCases := []struct {
+		name string
+		user hashTestUser
+	}{
+		{"value fields", hashTestUser{Name: "alpha", Age: 30, Active: true, Score: 4.5, CreatedAt: createdAt, Tags: []string{"a", "b"}, Note: "x"}},
+		{"pointer fields", hashTestUser{Name: "beta", Nickname: &nickname, Level: &level, DeletedAt: &deletedAt, Address: &hashTestAddress{City: "jkt"}}},
+		{"nil pointers", hashTestUser{Name: "gamma", CreatedAt: createdAt}},
+		{"zero time", hashTestUser{Name: "delta"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var res hashTestUser
+			hashTestRoundTrip(t, tc.user, &res)
+
+			if !res.CreatedAt.Equal(tc.user.CreatedAt) {
+				t.Errorf("createdAt = %v, want %v", res.CreatedAt, tc.user.CreatedAt)
+			}
+			if (res.DeletedAt == nil) != (tc.user.DeletedAt == nil) || (res.DeletedAt != nil && !res.DeletedAt.Equal(*tc.user.DeletedAt)) {
+				t.Errorf("deletedAt = %v, want %v", res.DeletedAt, tc.user.DeletedAt)
+			}
+			res.CreatedAt, res.DeletedAt = tc.user.CreatedAt, tc.user.DeletedAt
+			if !reflect.DeepEqual(res, tc.user) {
+				t.Errorf("got %+v, want %+v", res, tc.user)
+			}
+		})
+	}
+}
+
+func TestHashEncodeTime(t *testing.T) {
+	createdAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	testCases := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{"time", createdAt, "2024-05-06T07:08:09Z"},
+		{"pointer to time", &createdAt, "2024-05-06T07:08:09Z"},
+		{"string", "a", "a"},
+		{"nil time pointer", (*time.Time)(nil), (*time.Time)(nil)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := encodeHashValue(tc.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res != tc.want {
+				t.Errorf("got %#v, want %#v", res, tc.want)
+			}
+		})
+	}
+}
+
+func TestHashMap(t *testing.T) {
+	createdAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	hash, err := toHash(map[string]any{"name": "a", "createdAt": &createdAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash["createdAt"] != "2024-05-06T07:08:09Z" {
+		t.Errorf("createdAt = %#v", hash["createdAt"])
+	}
+
+	var res map[string]string
+	if err = fromHash(map[string]string{"name": "a"}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["name"] != "a" {
+		t.Errorf("got %v", res)
+	}
+}
@@ -0,0 +1,369 @@
+package fredis
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	IDEMPOTENCY_FOLDER = "idempotency"
+	IDEMPOTENCY_HEADER = "Idempotency-Key"
+)
+
+var (
+	ErrIdempotencyInProgress = errors.New("request with the same idempotency key is in progress")
+	ErrIdempotencyMismatch   = errors.New("idempotency key already used by another request")
+	ErrIdempotencyNotHeld    = errors.New("idempotency marker expired or taken by another request")
+)
+
+type IdempotentResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+type idempotencyRecord struct {
+	Done        bool                `json:"done"`
+	Fingerprint string              `json:"fingerprint"`
+	Token       string              `json:"token,omitempty"` //? Owner of the in progress marker
+	Response    *IdempotentResponse `json:"response,omitempty"`
+}
+
+type IdempotencyOption struct {
+	TTL         time.Duration //? How long the response is replayed, default 24h
+	LockTTL     time.Duration //? In progress marker, renewed every LockTTL/3 until Complete / Abort. Default 1m
+	MaxBodySize int64         //? Request body read for the fingerprint and response body stored by Middleware, default 1MB
+}
+
+func (o IdempotencyOption) withDefault() IdempotencyOption {
+	if o.TTL <= 0 {
+		o.TTL = 24 * time.Hour
+	}
+	if o.LockTTL <= 0 {
+		o.LockTTL = time.Minute
+	}
+	if o.MaxBodySize <= 0 {
+		o.MaxBodySize = 1 << 20
+	}
+	return o
+}
+
+// ? Begin -> process -> claim.Complete (or claim.Abort to allow a retry). See Middleware for http.
+// ? e.g. idem := fredis.NewIdempotency(rds, fredis.IdempotencyOption{})
+type Idempotency struct {
+	redis  *Redis
+	option IdempotencyOption
+}
+
+func NewIdempotency(r *Redis, option IdempotencyOption) *Idempotency {
+	return &Idempotency{redis: r, option: option.withDefault()}
+}
+
+func (i *Idempotency) key(key string) string {
+	return i.redis.prefixKey(BuildKey(IDEMPOTENCY_FOLDER, key))
+}
+
+// ? Return the existing record, or write the in progress marker.
+var idempotencyBeginScript = redis.NewScript(`
+local existing = redis.call("GET", KEYS[1])
+if existing then
+	return existing
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return false`)
+
+// ? The scripts below only touch the in progress marker holding the token ARGV[1].
+var idempotencyExtendScript = redis.NewScript(`
+local existing = redis.call("GET", KEYS[1])
+if not existing then
+	return 0
+end
+local record = cjson.decode(existing)
+if record.done or record.token ~= ARGV[1] then
+	return 0
+end
+return redis.call("PEXPIRE", KEYS[1], ARGV[2])`)
+
+var idempotencyCompleteScript = redis.NewScript(`
+local existing = redis.call("GET", KEYS[1])
+if not existing then
+	return 0
+end
+local record = cjson.decode(existing)
+if record.done or record.token ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1`)
+
+var idempotencyAbortScript = redis.NewScript(`
+local existing = redis.call("GET", KEYS[1])
+if not existing then
+	return 0
+end
+local record = cjson.decode(existing)
+if record.done or record.token ~= ARGV[1] then
+	return 0
+end
+return redis.call("DEL", KEYS[1])`)
+
+// ? claim non nil = first time, process the request then claim.Complete / claim.Abort. Otherwise stored is the response
+// ? to replay, ErrIdempotencyInProgress for a concurrent duplicate, ErrIdempotencyMismatch when the key was used by another request.
+// ? fingerprint identify the request (e.g. hash of method, path and body), empty = not checked.
+func (i *Idempotency) Begin(ctx context.Context, key, fingerprint string) (claim *IdempotencyClaim, stored *IdempotentResponse, err error) {
+	token := fid.GenerateID()
+	marker, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint, Token: token})
+	if err != nil {
+		return
+	}
+
+	fullKey := i.key(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.idempotency.begin: key=%s", fullKey)))
+	raw, err := idempotencyBeginScript.Run(ctx, i.redis.open(), []string{fullKey}, marker, i.option.LockTTL.Milliseconds()).Text()
+	if errors.Is(err, redis.Nil) {
+		claim = &IdempotencyClaim{idempotency: i, key: fullKey, fingerprint: fingerprint, token: token}
+		claim.startRenew()
+		return claim, nil, nil
+	}
+	if err != nil {
+		log.Println(fullKey, aurora.Red(err))
+		return
+	}
+
+	var record idempotencyRecord
+	if err = json.Unmarshal([]byte(raw), &record); err != nil {
+		log.Println(fullKey, aurora.Red(err))
+		return
+	}
+	switch {
+	case fingerprint != "" && record.Fingerprint != "" && record.Fingerprint != fingerprint:
+		return nil, nil, ErrIdempotencyMismatch
+	case !record.Done:
+		return nil, nil, ErrIdempotencyInProgress
+	}
+	return nil, record.Response, nil
+}
+
+// ? The in progress marker of a request being processed, renewed until Complete or Abort.
+type IdempotencyClaim struct {
+	idempotency *Idempotency
+	key         string
+	fingerprint string
+	token       string
+
+	stopRenew context.CancelFunc
+	renewDone chan struct{}
+}
+
+func (c *IdempotencyClaim) startRenew() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopRenew, c.renewDone = cancel, make(chan struct{})
+	lockTTL := c.idempotency.option.LockTTL
+
+	go func() {
+		defer close(c.renewDone)
+		ticker := time.NewTicker(max(lockTTL/3, time.Millisecond))
+		defer ticker.Stop()
+
+		lastExtended := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			res, err := idempotencyExtendScript.Run(ctx, c.idempotency.redis.open(), []string{c.key}, c.token, lockTTL.Milliseconds()).Int64()
+			switch {
+			case err == nil && res == 1:
+				lastExtended = time.Now()
+			case err == nil, time.Since(lastExtended) > lockTTL:
+				log.Println(c.key, aurora.Red(ErrIdempotencyNotHeld))
+				return
+			case ctx.Err() == nil:
+				log.Println(c.key, aurora.Red(err))
+			}
+		}
+	}()
+}
+
+func (c *IdempotencyClaim) stop() {
+	c.stopRenew()
+	<-c.renewDone
+}
+
+// ? Store the final response, replayed for TTL. ErrIdempotencyNotHeld when the marker expired meanwhile.
+func (c *IdempotencyClaim) Complete(ctx context.Context, response IdempotentResponse) (err error) {
+	c.stop()
+	raw, err := json.Marshal(idempotencyRecord{Done: true, Fingerprint: c.fingerprint, Response: &response})
+	if err != nil {
+		return
+	}
+
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.idempotency.complete: key=%s", c.key)))
+	res, err := idempotencyCompleteScript.Run(ctx, c.idempotency.redis.open(), []string{c.key}, c.token, raw, c.idempotency.option.TTL.Milliseconds()).Int64()
+	if err != nil {
+		log.Println(c.key, aurora.Red(err))
+		return
+	}
+	if res == 0 {
+		return ErrIdempotencyNotHeld
+	}
+	return
+}
+
+// ? Remove the in progress marker so the request can be retried (failed processing).
+func (c *IdempotencyClaim) Abort(ctx context.Context) (err error) {
+	c.stop()
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.idempotency.abort: key=%s", c.key)))
+	res, err := idempotencyAbortScript.Run(ctx, c.idempotency.redis.open(), []string{c.key}, c.token).Int64()
+	if err != nil {
+		log.Println(c.key, aurora.Red(err))
+		return
+	}
+	if res == 0 {
+		return ErrIdempotencyNotHeld
+	}
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                 MIDDLEWARE                                 */
+/* -------------------------------------------------------------------------- */
+
+// ? Request without Idempotency-Key pass through. A replay get the stored response with Idempotent-Replayed: true,
+// ? a concurrent duplicate 409, a key reused for another request (method, path, body) 422.
+// ? A 5xx response or a panic is not stored, the partner can retry. When Redis is unavailable the request
+// ? is refused (503) rather than risking a double processing.
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IDEMPOTENCY_HEADER)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fingerprint, err := i.fingerprint(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		claim, stored, err := i.Begin(r.Context(), key, fingerprint)
+		switch {
+		case errors.Is(err, ErrIdempotencyInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, ErrIdempotencyMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		case stored != nil:
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			return
+		}
+
+		ctx := context.WithoutCancel(r.Context())
+		recorder := &idempotencyRecorder{ResponseWriter: w, maxBodySize: i.option.MaxBodySize}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if err := claim.Abort(ctx); err != nil {
+					log.Println(key, aurora.Red(err))
+				}
+				panic(recovered)
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode == 0 {
+			recorder.WriteHeader(http.StatusOK)
+		}
+		//? A response too large to store is not replayed, the partner can retry.
+		if recorder.statusCode >= http.StatusInternalServerError || recorder.bodyTooLarge {
+			if err := claim.Abort(ctx); err != nil {
+				log.Println(key, aurora.Red(err))
+			}
+			return
+		}
+		err = claim.Complete(ctx, IdempotentResponse{
+			StatusCode: recorder.statusCode,
+			Header:     recorder.header,
+			Body:       recorder.body.Bytes(),
+		})
+		if err != nil {
+			log.Println(key, aurora.Red(err))
+		}
+	})
+}
+
+func (i *Idempotency) fingerprint(r *http.Request) (res string, err error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, i.option.MaxBodySize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(body)) > i.option.MaxBodySize {
+		return "", errors.New("request body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header //? Snapshot at WriteHeader
+	body       bytes.Buffer
+
+	maxBodySize  int64
+	bodyTooLarge bool //? Recording stopped at maxBodySize
+}
+
+func (w *idempotencyRecorder) WriteHeader(statusCode int) {
+	if w.statusCode != 0 {
+		return
+	}
+	w.statusCode, w.header = statusCode, w.ResponseWriter.Header().Clone()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.bodyTooLarge {
+		if int64(w.body.Len()+len(data)) > w.maxBodySize {
+			w.bodyTooLarge = true
+			w.body.Reset()
+		} else {
+			w.body.Write(data)
+		}
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
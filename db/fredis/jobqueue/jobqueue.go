@@ -0,0 +1,344 @@
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dansbeer/go-forge/db/fredis"
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	KEY_FOLDER = "jobqueue"
+)
+
+var (
+	ErrDuplicate   = errors.New("job with the same unique key already exists")
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobActive   = errors.New("job is running")
+)
+
+type Job_State int
+
+const (
+	Job_State_Delayed Job_State = iota //? Waiting for RunAt (or the next retry)
+	Job_State_Ready                    //? Waiting for a worker
+	Job_State_Active                   //? Running, until done or the visibility timeout
+	Job_State_Dead                     //? Failed MaxRetry+1 times
+)
+
+func (index Job_State) String() string {
+	return []string{
+		"delayed",
+		"ready",
+		"active",
+		"dead",
+	}[index]
+}
+
+func parseJobState(value string) Job_State {
+	if index := slices.Index([]string{"delayed", "ready", "active", "dead"}, value); index >= 0 {
+		return Job_State(index)
+	}
+	return Job_State_Delayed
+}
+
+type Job[T any] struct {
+	ID        string
+	Payload   T
+	State     Job_State
+	Attempts  int //? Including the running one
+	MaxRetry  int
+	RunAt     time.Time
+	CreatedAt time.Time
+	UniqueKey string
+	LastError string
+}
+
+type Option struct {
+	Concurrency  int           //? Jobs running at the same time per Work, default 10
+	MaxRetry     int           //? Default 3, so 4 attempts
+	BackoffMin   time.Duration //? First retry delay, doubled on every attempt. Default 1s
+	BackoffMax   time.Duration //? Default 1h
+	Visibility   time.Duration //? A job running longer is given to another worker (crashed worker), default 5m
+	PollInterval time.Duration //? Default 1s
+}
+
+func (o Option) withDefault() Option {
+	if o.Concurrency <= 0 {
+		o.Concurrency = 10
+	}
+	if o.MaxRetry <= 0 {
+		o.MaxRetry = 3
+	}
+	if o.BackoffMin <= 0 {
+		o.BackoffMin = time.Second
+	}
+	if o.BackoffMax < o.BackoffMin {
+		o.BackoffMax = max(time.Hour, o.BackoffMin)
+	}
+	if o.Visibility <= 0 {
+		o.Visibility = 5 * time.Minute
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	return o
+}
+
+type EnqueueOption struct {
+	Delay     time.Duration
+	RunAt     time.Time //? Win over Delay
+	UniqueKey string    //? Enqueue return ErrDuplicate while a job with this key is not done
+	MaxRetry  int       //? 0 = Option.MaxRetry, -1 = never retry
+}
+
+// ? Delayed jobs are in a sorted set scored by run time, ready jobs in a list, running jobs in a sorted set
+// ? scored by their visibility deadline. Every key of a queue share the {name} hash tag (Redis Cluster).
+// ? e.g. queue := jobqueue.New[Reminder](rds, "reminder")
+type Queue[T any] struct {
+	redis  *fredis.Redis
+	name   string
+	option Option
+}
+
+func New[T any](rds *fredis.Redis, name string) *Queue[T] {
+	return &Queue[T]{redis: rds, name: name, option: Option{}.withDefault()}
+}
+
+func (q *Queue[T]) SetOption(option Option) *Queue[T] {
+	q.option = option.withDefault()
+	return q
+}
+
+func (q *Queue[T]) key(key ...string) string {
+	return q.redis.BuildKey(append([]string{KEY_FOLDER, "{" + q.name + "}"}, key...)...)
+}
+
+func (q *Queue[T]) jobKey(id string) string {
+	return q.key("job", id)
+}
+
+func (q *Queue[T]) uniqueKey(uniqueKey string) string {
+	return q.key("unique", uniqueKey)
+}
+
+func (q *Queue[T]) stateKey(state Job_State) string {
+	return q.key(state.String())
+}
+
+func (q *Queue[T]) run(ctx context.Context, script *redis.Script, keys []string, args ...any) (res any, err error) {
+	client := q.redis.GetUniversalClient()
+	if client == nil {
+		return nil, fmt.Errorf("redis client is not available")
+	}
+	if res, err = script.Run(ctx, client, keys, args...).Result(); err != nil && !errors.Is(err, redis.Nil) {
+		log.Println(q.name, aurora.Red(err))
+		return
+	}
+	return res, nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                   ENQUEUE                                  */
+/* -------------------------------------------------------------------------- */
+
+var enqueueScript = redis.NewScript(`
+if KEYS[4] then
+	local existing = redis.call("GET", KEYS[4])
+	if existing then
+		return {0, existing}
+	end
+	redis.call("SET", KEYS[4], ARGV[1])
+end
+
+redis.call("HSET", KEYS[1], "payload", ARGV[2], "attempts", 0, "max_retry", ARGV[3],
+	"run_at", ARGV[4], "created_at", ARGV[5], "unique_key", ARGV[6])
+if tonumber(ARGV[4]) <= tonumber(ARGV[5]) then
+	redis.call("HSET", KEYS[1], "state", "ready")
+	redis.call("LPUSH", KEYS[3], ARGV[1])
+else
+	redis.call("HSET", KEYS[1], "state", "delayed")
+	redis.call("ZADD", KEYS[2], ARGV[4], ARGV[1])
+end
+return {1, ARGV[1]}`)
+
+// ? On ErrDuplicate id is the existing job.
+func (q *Queue[T]) Enqueue(ctx context.Context, payload T, opt EnqueueOption) (id string, err error) {
+	raw, err := fredis.JSONCodec.Marshal(payload)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	now := time.Now()
+	runAt := now.Add(opt.Delay)
+	if !opt.RunAt.IsZero() {
+		runAt = opt.RunAt
+	}
+	maxRetry := opt.MaxRetry
+	switch {
+	case maxRetry == 0:
+		maxRetry = q.option.MaxRetry
+	case maxRetry < 0:
+		maxRetry = 0
+	}
+
+	id = fid.GenerateID()
+	keys := []string{q.jobKey(id), q.stateKey(Job_State_Delayed), q.stateKey(Job_State_Ready)}
+	if opt.UniqueKey != "" {
+		keys = append(keys, q.uniqueKey(opt.UniqueKey))
+	}
+	res, err := q.run(ctx, enqueueScript, keys, id, raw, maxRetry, runAt.UnixMilli(), now.UnixMilli(), opt.UniqueKey)
+	if err != nil {
+		return "", err
+	}
+
+	asSlice := res.([]any)
+	if id = asSlice[1].(string); asSlice[0].(int64) == 0 {
+		err = ErrDuplicate
+		return
+	}
+	log.Println(aurora.BrightRed(fmt.Sprintf("jobqueue.enqueue: queue=%s id=%s run_at=%s", q.name, id, runAt.Format(time.RFC3339))))
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                 INSPECTION                                 */
+/* -------------------------------------------------------------------------- */
+
+func (q *Queue[T]) parseJob(id string, hash map[string]string) (res Job[T], err error) {
+	if len(hash) == 0 {
+		err = ErrJobNotFound
+		return
+	}
+
+	res = Job[T]{ID: id, State: parseJobState(hash["state"]), UniqueKey: hash["unique_key"], LastError: hash["last_error"]}
+	res.Attempts, _ = strconv.Atoi(hash["attempts"])
+	res.MaxRetry, _ = strconv.Atoi(hash["max_retry"])
+	runAt, _ := strconv.ParseInt(hash["run_at"], 10, 64)
+	createdAt, _ := strconv.ParseInt(hash["created_at"], 10, 64)
+	res.RunAt, res.CreatedAt = time.UnixMilli(runAt), time.UnixMilli(createdAt)
+	if err = fredis.JSONCodec.Unmarshal([]byte(hash["payload"]), &res.Payload); err != nil {
+		log.Println(q.name, id, aurora.Red(err))
+		err = fmt.Errorf("decode job %s: %w", id, err)
+		return
+	}
+	return
+}
+
+func (q *Queue[T]) Get(ctx context.Context, id string) (res Job[T], err error) {
+	hash, err := q.redis.GetUniversalClient().HGetAll(ctx, q.jobKey(id)).Result()
+	if err != nil {
+		log.Println(q.name, id, aurora.Red(err))
+		return
+	}
+	return q.parseJob(id, hash)
+}
+
+// ? Delayed by run time, ready by execution order, active by visibility deadline, dead newest first.
+func (q *Queue[T]) List(ctx context.Context, state Job_State, offset, limit int64) (res []Job[T], err error) {
+	rdb := q.redis.GetUniversalClient()
+	key := q.stateKey(state)
+
+	var listID []string
+	switch state {
+	case Job_State_Ready: //? LPUSH + RPOP, the next job is the last of the list
+		if listID, err = rdb.LRange(ctx, key, -(offset + limit), -(offset + 1)).Result(); err == nil {
+			slices.Reverse(listID)
+		}
+	case Job_State_Dead:
+		listID, err = rdb.ZRevRange(ctx, key, offset, offset+limit-1).Result()
+	default:
+		listID, err = rdb.ZRange(ctx, key, offset, offset+limit-1).Result()
+	}
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+
+	listCmd := make([]*redis.MapStringStringCmd, len(listID))
+	if _, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range listID {
+			listCmd[i] = pipe.HGetAll(ctx, q.jobKey(id))
+		}
+		return nil
+	}); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+
+	for i, id := range listID {
+		job, errParse := q.parseJob(id, listCmd[i].Val())
+		if errParse != nil { //? Finished between the two commands
+			continue
+		}
+		res = append(res, job)
+	}
+	return
+}
+
+type Stats struct {
+	Delayed int64
+	Ready   int64
+	Active  int64
+	Dead    int64
+}
+
+func (q *Queue[T]) Stats(ctx context.Context) (res Stats, err error) {
+	var delayed, ready, active, dead *redis.IntCmd
+	if _, err = q.redis.GetUniversalClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		delayed = pipe.ZCard(ctx, q.stateKey(Job_State_Delayed))
+		ready = pipe.LLen(ctx, q.stateKey(Job_State_Ready))
+		active = pipe.ZCard(ctx, q.stateKey(Job_State_Active))
+		dead = pipe.ZCard(ctx, q.stateKey(Job_State_Dead))
+		return nil
+	}); err != nil {
+		log.Println(q.name, aurora.Red(err))
+		return
+	}
+	return Stats{Delayed: delayed.Val(), Ready: ready.Val(), Active: active.Val(), Dead: dead.Val()}, nil
+}
+
+var cancelScript = redis.NewScript(`
+local state = redis.call("HGET", KEYS[1], "state")
+if not state then
+	return 0
+end
+if state == "active" then
+	return -1
+end
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("LREM", KEYS[3], 0, ARGV[1])
+redis.call("ZREM", KEYS[4], ARGV[1])
+local uniqueKey = redis.call("HGET", KEYS[1], "unique_key")
+if uniqueKey and uniqueKey ~= "" then
+	redis.call("DEL", ARGV[2] .. uniqueKey)
+end
+redis.call("DEL", KEYS[1])
+return 1`)
+
+// ? Remove a delayed, ready or dead job. A running job can't be cancelled (ErrJobActive).
+func (q *Queue[T]) Cancel(ctx context.Context, id string) (err error) {
+	res, err := q.run(ctx, cancelScript,
+		[]string{q.jobKey(id), q.stateKey(Job_State_Delayed), q.stateKey(Job_State_Ready), q.stateKey(Job_State_Dead)},
+		id, q.uniqueKey(""))
+	if err != nil {
+		return
+	}
+	switch res.(int64) {
+	case 0:
+		return ErrJobNotFound
+	case -1:
+		return ErrJobActive
+	}
+	log.Println(aurora.BrightRed(fmt.Sprintf("jobqueue.cancel: queue=%s id=%s", q.name, id)))
+	return
+}
@@ -0,0 +1,216 @@
+package jobqueue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dansbeer/go-forge/db/fredis"
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+type Handler[T any] func(ctx context.Context, job Job[T]) error
+
+const promoteBatchSize = 100
+
+// ? Move the due delayed jobs to ready, and the active jobs over their visibility deadline back to ready
+// ? (or dead when they have no retry left).
+var promoteScript = redis.NewScript(`
+local now, limit = tonumber(ARGV[1]), tonumber(ARGV[2])
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", now, "LIMIT", 0, limit)
+for _, id in ipairs(due) do
+	redis.call("ZREM", KEYS[1], id)
+	redis.call("HSET", ARGV[3] .. id, "state", "ready")
+	redis.call("LPUSH", KEYS[2], id)
+end
+
+local expired = redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", now, "LIMIT", 0, limit)
+for _, id in ipairs(expired) do
+	local job = ARGV[3] .. id
+	redis.call("ZREM", KEYS[3], id)
+	local attempts = tonumber(redis.call("HGET", job, "attempts") or "0")
+	local maxRetry = tonumber(redis.call("HGET", job, "max_retry") or "0")
+	if attempts > maxRetry then
+		redis.call("HSET", job, "state", "dead", "token", "", "last_error", "visibility timeout")
+		redis.call("ZADD", KEYS[4], now, id)
+		local uniqueKey = redis.call("HGET", job, "unique_key")
+		if uniqueKey and uniqueKey ~= "" then
+			redis.call("DEL", ARGV[4] .. uniqueKey)
+		end
+	elseif redis.call("EXISTS", job) == 1 then
+		redis.call("HSET", job, "state", "ready", "token", "", "last_error", "visibility timeout")
+		redis.call("RPUSH", KEYS[2], id)
+	end
+end
+return #due + #expired`)
+
+// ? Job key are built from ARGV, they share the {name} hash tag with KEYS.
+var dequeueScript = redis.NewScript(`
+while true do
+	local id = redis.call("RPOP", KEYS[1])
+	if not id then
+		return false
+	end
+	local job = ARGV[4] .. id
+	if redis.call("EXISTS", job) == 1 then
+		redis.call("ZADD", KEYS[2], tonumber(ARGV[1]) + tonumber(ARGV[2]), id)
+		redis.call("HINCRBY", job, "attempts", 1)
+		redis.call("HSET", job, "state", "active", "token", ARGV[3])
+		return {id, redis.call("HGETALL", job)}
+	end
+end`)
+
+// ? token guard against a worker finishing a job that was given to another worker after the visibility timeout.
+var completeScript = redis.NewScript(`
+if redis.call("HGET", KEYS[2], "token") ~= ARGV[2] then
+	return 0
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+local uniqueKey = redis.call("HGET", KEYS[2], "unique_key")
+if uniqueKey and uniqueKey ~= "" then
+	redis.call("DEL", ARGV[3] .. uniqueKey)
+end
+redis.call("DEL", KEYS[2])
+return 1`)
+
+// ? ARGV[4] retry at, 0 = dead.
+var failScript = redis.NewScript(`
+if redis.call("HGET", KEYS[2], "token") ~= ARGV[2] then
+	return 0
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+if ARGV[4] == "0" then
+	redis.call("HSET", KEYS[2], "state", "dead", "token", "", "last_error", ARGV[5])
+	redis.call("ZADD", KEYS[4], ARGV[3], ARGV[1])
+	local uniqueKey = redis.call("HGET", KEYS[2], "unique_key")
+	if uniqueKey and uniqueKey ~= "" then
+		redis.call("DEL", ARGV[6] .. uniqueKey)
+	end
+	return 2
+end
+redis.call("HSET", KEYS[2], "state", "delayed", "token", "", "run_at", ARGV[4], "last_error", ARGV[5])
+redis.call("ZADD", KEYS[3], ARGV[4], ARGV[1])
+return 1`)
+
+// ? Block until ctx is done, then wait for the running jobs. A job is removed when handler return nil,
+// ? otherwise retried with exponential backoff. The handler ctx expire at the visibility timeout.
+func (q *Queue[T]) Work(ctx context.Context, handler Handler[T]) (err error) {
+	opt := q.option
+	slot := make(chan struct{}, opt.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	log.Println(aurora.BrightRed(fmt.Sprintf("jobqueue.work: queue=%s concurrency=%d", q.name, opt.Concurrency)))
+	var lastPromote time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case slot <- struct{}{}:
+		}
+
+		if time.Since(lastPromote) >= opt.PollInterval {
+			q.promote(ctx)
+			lastPromote = time.Now()
+		}
+		job, token, found, errDequeue := q.dequeue(ctx)
+		if errDequeue != nil || !found {
+			<-slot
+			if !fredis.SleepCtx(ctx, opt.PollInterval) {
+				return nil
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slot }()
+			q.process(ctx, handler, job, token)
+		}()
+	}
+}
+
+func (q *Queue[T]) promote(ctx context.Context) {
+	q.run(ctx, promoteScript,
+		[]string{q.stateKey(Job_State_Delayed), q.stateKey(Job_State_Ready), q.stateKey(Job_State_Active), q.stateKey(Job_State_Dead)},
+		time.Now().UnixMilli(), promoteBatchSize, q.jobKey(""), q.uniqueKey(""))
+}
+
+func (q *Queue[T]) dequeue(ctx context.Context) (res Job[T], token string, found bool, err error) {
+	token = fid.GenerateID()
+	raw, err := q.run(ctx, dequeueScript, []string{q.stateKey(Job_State_Ready), q.stateKey(Job_State_Active)},
+		time.Now().UnixMilli(), q.option.Visibility.Milliseconds(), token, q.jobKey(""))
+	if err != nil || raw == nil {
+		return
+	}
+
+	asSlice := raw.([]any)
+	id, listField := asSlice[0].(string), asSlice[1].([]any)
+	hash := make(map[string]string, len(listField)/2)
+	for i := 0; i+1 < len(listField); i += 2 {
+		hash[listField[i].(string)], _ = listField[i+1].(string)
+	}
+	if res, err = q.parseJob(id, hash); err != nil { //? Can't be decoded, no retry will help
+		q.finish(context.WithoutCancel(ctx), id, token, err, 0)
+		return
+	}
+	return res, token, true, nil
+}
+
+func (q *Queue[T]) process(ctx context.Context, handler Handler[T], job Job[T], token string) {
+	ctx = context.WithoutCancel(ctx) //? Let the running jobs finish on shutdown
+	jobCtx, cancel := context.WithTimeout(ctx, q.option.Visibility)
+	defer cancel()
+
+	err := func() (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("panic: %v", recovered)
+			}
+		}()
+		return handler(jobCtx, job)
+	}()
+	if err != nil {
+		log.Println(q.name, job.ID, aurora.Red(err))
+	}
+	var retryAfter time.Duration
+	if job.Attempts <= job.MaxRetry {
+		retryAfter = q.backoff(job.Attempts)
+	}
+	q.finish(ctx, job.ID, token, err, retryAfter)
+}
+
+// ? retryAfter 0 = no retry left, the job goes to dead.
+func (q *Queue[T]) finish(ctx context.Context, id, token string, errJob error, retryAfter time.Duration) {
+	if errJob == nil {
+		if res, err := q.run(ctx, completeScript, []string{q.stateKey(Job_State_Active), q.jobKey(id)},
+			id, token, q.uniqueKey("")); err == nil && res.(int64) == 0 {
+			log.Println(q.name, id, aurora.Yellow("job was given to another worker (visibility timeout)"))
+		}
+		return
+	}
+
+	var retryAt int64
+	if retryAfter > 0 {
+		retryAt = time.Now().Add(retryAfter).UnixMilli()
+	}
+	q.run(ctx, failScript,
+		[]string{q.stateKey(Job_State_Active), q.jobKey(id), q.stateKey(Job_State_Delayed), q.stateKey(Job_State_Dead)},
+		id, token, time.Now().UnixMilli(), retryAt, errJob.Error(), q.uniqueKey(""))
+}
+
+// ? BackoffMin * 2^(attempts-1) capped at BackoffMax, with up to 10% jitter.
+func (q *Queue[T]) backoff(attempts int) time.Duration {
+	wait := q.option.BackoffMin
+	for i := 1; i < attempts && wait < q.option.BackoffMax; i++ {
+		wait *= 2
+	}
+	wait = min(wait, q.option.BackoffMax)
+	return wait + time.Duration(rand.Int63n(int64(wait)/10+1))
+}
@@ -0,0 +1,108 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+// ? Values are written like Set: native value as is, the rest as json.
+func (r *Redis) LPush(ctx context.Context, key string, values ...any) (length int64, err error) {
+	return r.push(ctx, "lpush", key, values)
+}
+
+func (r *Redis) RPush(ctx context.Context, key string, values ...any) (length int64, err error) {
+	return r.push(ctx, "rpush", key, values)
+}
+
+func (r *Redis) push(ctx context.Context, command, key string, values []any) (length int64, err error) {
+	listValue, err := encodeListValue(values)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.%s: key=%s", command, key)))
+	rdb := r.open()
+	if command == "lpush" {
+		length, err = rdb.LPush(ctx, key, listValue...).Result()
+	} else {
+		length, err = rdb.RPush(ctx, key, listValue...).Result()
+	}
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? ptrDecodeTo follow Get: *string, *[]byte or json.
+func (r *Redis) LPop(ctx context.Context, key string, ptrDecodeTo any) (found bool, err error) {
+	return r.pop(ctx, "lpop", key, ptrDecodeTo)
+}
+
+func (r *Redis) RPop(ctx context.Context, key string, ptrDecodeTo any) (found bool, err error) {
+	return r.pop(ctx, "rpop", key, ptrDecodeTo)
+}
+
+func (r *Redis) pop(ctx context.Context, command, key string, ptrDecodeTo any) (found bool, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.%s: key=%s", command, aurora.BrightBlue(key))))
+	rdb := r.open()
+
+	var raw string
+	if command == "lpop" {
+		raw, err = rdb.LPop(ctx, key).Result()
+	} else {
+		raw, err = rdb.RPop(ctx, key).Result()
+	}
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+
+	if err = decodeValue(raw, ptrDecodeTo); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return true, nil
+}
+
+// ? start & stop inclusive, -1 = last.
+func (r *Redis) LRange(ctx context.Context, key string, start, stop int64) (res []string, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.lrange: key=%s", aurora.BrightBlue(key))))
+	if res, err = r.open().LRange(ctx, key, start, stop).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? Keep only start..stop, e.g. the last 100 events: rds.LPush(...) then rds.LTrim(ctx, key, 0, 99)
+func (r *Redis) LTrim(ctx context.Context, key string, start, stop int64) (err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.ltrim: key=%s", key)))
+	if err = r.open().LTrim(ctx, key, start, stop).Err(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) LLen(ctx context.Context, key string) (res int64, err error) {
+	key = r.prefixKey(key)
+	if res, err = r.open().LLen(ctx, key).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
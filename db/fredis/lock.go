@@ -0,0 +1,207 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	LOCK_FOLDER = "lock"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("lock not acquired")
+	ErrLockNotHeld     = errors.New("lock is not held anymore")
+	ErrLockInvalidTTL  = errors.New("lock ttl must be greater than 0")
+)
+
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// ? fullKey is already prefixed.
+func (r *Redis) tryLock(ctx context.Context, fullKey string, ttl time.Duration) (token string, acquired bool, err error) {
+	token = fid.GenerateID()
+	if acquired, err = r.open().SetNX(ctx, fullKey, token, ttl).Result(); err != nil {
+		log.Println(fullKey, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) releaseLock(ctx context.Context, fullKey, token string) (released bool, err error) {
+	res, err := unlockScript.Run(ctx, r.open(), []string{fullKey}, token).Int64()
+	if err != nil {
+		log.Println(fullKey, aurora.Red(err))
+		return
+	}
+	return res == 1, nil
+}
+
+type LockOption struct {
+	RetryMin         time.Duration //? First wait of Acquire, doubled on every retry. Default 50ms
+	RetryMax         time.Duration //? Default 1s
+	DisableAutoRenew bool          //? By default the lock is extended every ttl/3 until Unlock
+}
+
+func (o LockOption) withDefault() LockOption {
+	if o.RetryMin <= 0 {
+		o.RetryMin = 50 * time.Millisecond
+	}
+	if o.RetryMax < o.RetryMin {
+		o.RetryMax = max(time.Second, o.RetryMin)
+	}
+	return o
+}
+
+type Lock struct {
+	redis *Redis
+	key   string
+	token string
+	ttl   time.Duration
+
+	stopRenew context.CancelFunc
+	renewDone chan struct{}
+	lost      chan struct{}
+	lostOnce  sync.Once
+}
+
+// ? Single attempt, return ErrLockNotAcquired when another instance hold the lock.
+// ? e.g. lock, err := rds.Lock(ctx, "cron:daily-report", 30*time.Second)
+func (r *Redis) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return r.LockWithOption(ctx, name, ttl, LockOption{})
+}
+
+func (r *Redis) LockWithOption(ctx context.Context, name string, ttl time.Duration, opt LockOption) (res *Lock, err error) {
+	if ttl <= 0 {
+		err = ErrLockInvalidTTL
+		return
+	}
+	key := r.prefixKey(BuildKey(LOCK_FOLDER, name))
+	token, acquired, err := r.tryLock(ctx, key, ttl)
+	if err != nil {
+		return
+	}
+	if !acquired {
+		err = ErrLockNotAcquired
+		return
+	}
+
+	res = &Lock{redis: r, key: key, token: token, ttl: ttl, lost: make(chan struct{})}
+	if !opt.DisableAutoRenew {
+		res.startRenew()
+	}
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.lock: key=%s", key)))
+	return
+}
+
+// ? Block until the lock is acquired or ctx is done.
+func (r *Redis) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return r.AcquireWithOption(ctx, name, ttl, LockOption{})
+}
+
+func (r *Redis) AcquireWithOption(ctx context.Context, name string, ttl time.Duration, opt LockOption) (res *Lock, err error) {
+	opt = opt.withDefault()
+	wait := opt.RetryMin
+	for {
+		if res, err = r.LockWithOption(ctx, name, ttl, opt); !errors.Is(err, ErrLockNotAcquired) {
+			return
+		}
+
+		jitter := time.Duration(rand.Int63n(int64(wait)/2 + 1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait/2 + jitter):
+		}
+		wait = min(wait*2, opt.RetryMax)
+	}
+}
+
+func (l *Lock) startRenew() {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.stopRenew, l.renewDone = cancel, make(chan struct{})
+
+	go func() {
+		defer close(l.renewDone)
+		ticker := time.NewTicker(max(l.ttl/3, time.Millisecond))
+		defer ticker.Stop()
+
+		lastExtended := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := l.Extend(ctx, l.ttl)
+			switch {
+			case err == nil:
+				lastExtended = time.Now()
+			case errors.Is(err, ErrLockNotHeld), time.Since(lastExtended) > l.ttl:
+				l.markLost()
+				return
+			}
+		}
+	}()
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() {
+		log.Println(aurora.Red(fmt.Sprintf("redis.lock lost: key=%s", l.key)))
+		close(l.lost)
+	})
+}
+
+// ? Closed when the auto renewal found the lock expired or taken, the job should stop.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) (err error) {
+	res, err := extendScript.Run(ctx, l.redis.open(), []string{l.key}, l.token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		log.Println(l.key, aurora.Red(err))
+		return
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+	return
+}
+
+func (l *Lock) Unlock(ctx context.Context) (err error) {
+	if l.stopRenew != nil {
+		l.stopRenew()
+		<-l.renewDone
+	}
+
+	released, err := l.redis.releaseLock(ctx, l.key, l.token)
+	if err != nil {
+		return
+	}
+	if !released {
+		l.markLost()
+		return ErrLockNotHeld
+	}
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.unlock: key=%s", l.key)))
+	return
+}
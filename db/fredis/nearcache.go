@@ -0,0 +1,243 @@
+package fredis
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+)
+
+const (
+	NEAR_CACHE_FOLDER = "nearcache"
+)
+
+type NearCacheOption struct {
+	MaxEntries int           //? LRU bound of the local layer, default 10000
+	LocalTTL   time.Duration //? Max age of a local entry, bound the staleness when an invalidation is lost. Default 1m
+	Channel    string        //? Invalidation channel, default nearcache:<name>
+}
+
+func (o NearCacheOption) withDefault(name string) NearCacheOption {
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = 10000
+	}
+	if o.LocalTTL <= 0 {
+		o.LocalTTL = time.Minute
+	}
+	if o.Channel == "" {
+		o.Channel = BuildKey(NEAR_CACHE_FOLDER, name)
+	}
+	return o
+}
+
+type NearCacheStats struct {
+	LocalHit     int64
+	LocalMiss    int64
+	RemoteHit    int64
+	RemoteMiss   int64
+	Eviction     int64 //? Removed by the LRU bound
+	Invalidation int64 //? Removed by a write of this pod or another one
+}
+
+type nearCacheEntry[T any] struct {
+	key      string
+	value    T
+	expireAt time.Time
+}
+
+type nearCacheInvalidation struct {
+	Source   string   `json:"source"`
+	ListKey  []string `json:"keys"`
+	ClearAll bool     `json:"all"`
+}
+
+// ? In-process LRU in front of a Cache, the other pods are told to drop their copy through Pub/Sub on every
+// ? Set / Delete / Invalidate. Only the writes made through a NearCache (or Invalidate) are propagated.
+// ? RESP3 client side caching (CLIENT TRACKING) is not used: go-redis doesn't expose the push messages yet.
+// ? The value is shared between callers, don't mutate it.
+type NearCache[T any] struct {
+	cache  *Cache[T]
+	option NearCacheOption
+	source string
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	localHit, localMiss, remoteHit, remoteMiss, eviction, invalidation atomic.Int64
+}
+
+// ? The invalidation subscriber run until ctx is done.
+// ? e.g. config := fredis.NewNearCache(ctx, fredis.NewCache[Config](rds, nil), "config", fredis.NearCacheOption{})
+func NewNearCache[T any](ctx context.Context, cache *Cache[T], name string, option NearCacheOption) (res *NearCache[T]) {
+	res = &NearCache[T]{
+		cache:   cache,
+		option:  option.withDefault(name),
+		source:  fid.GenerateID(),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	go func() {
+		err := Subscribe(ctx, cache.redis, func(ctx context.Context, channel string, msg nearCacheInvalidation) error {
+			if msg.Source != res.source {
+				res.invalidateLocal(msg.ClearAll, msg.ListKey...)
+			}
+			return nil
+		}, res.option.Channel)
+		if err != nil { //? Without invalidation the local entries live at most LocalTTL
+			log.Println(res.option.Channel, aurora.Red(err))
+		}
+	}()
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                 LOCAL LAYER                                */
+/* -------------------------------------------------------------------------- */
+
+func (n *NearCache[T]) getLocal(key string) (value T, found bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	element, found := n.entries[key]
+	if !found {
+		return
+	}
+	entry := element.Value.(*nearCacheEntry[T])
+	if !time.Now().Before(entry.expireAt) {
+		n.lru.Remove(element)
+		delete(n.entries, key)
+		return value, false
+	}
+	n.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+func (n *NearCache[T]) setLocal(key string, value T) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	entry := &nearCacheEntry[T]{key: key, value: value, expireAt: time.Now().Add(n.option.LocalTTL)}
+	if element, found := n.entries[key]; found {
+		element.Value = entry
+		n.lru.MoveToFront(element)
+		return
+	}
+	n.entries[key] = n.lru.PushFront(entry)
+	for n.lru.Len() > n.option.MaxEntries {
+		oldest := n.lru.Back()
+		n.lru.Remove(oldest)
+		delete(n.entries, oldest.Value.(*nearCacheEntry[T]).key)
+		n.eviction.Add(1)
+	}
+}
+
+func (n *NearCache[T]) invalidateLocal(clearAll bool, listKey ...string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if clearAll {
+		n.invalidation.Add(int64(len(n.entries)))
+		n.entries, n.lru = make(map[string]*list.Element), list.New()
+		return
+	}
+	for _, key := range listKey {
+		if element, found := n.entries[key]; found {
+			n.lru.Remove(element)
+			delete(n.entries, key)
+			n.invalidation.Add(1)
+		}
+	}
+}
+
+func (n *NearCache[T]) publish(ctx context.Context, clearAll bool, listKey ...string) error {
+	return n.cache.redis.Publish(ctx, n.option.Channel, nearCacheInvalidation{Source: n.source, ListKey: listKey, ClearAll: clearAll})
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                     API                                    */
+/* -------------------------------------------------------------------------- */
+
+func (n *NearCache[T]) Get(ctx context.Context, key string) (value T, found bool, err error) {
+	if value, found = n.getLocal(key); found {
+		n.localHit.Add(1)
+		return
+	}
+	n.localMiss.Add(1)
+
+	if value, found, err = n.cache.Get(ctx, key); err != nil {
+		return
+	}
+	if !found {
+		n.remoteMiss.Add(1)
+		return
+	}
+	n.remoteHit.Add(1)
+	n.setLocal(key, value)
+	return
+}
+
+// ? Same as Cache.GetOrLoad, the loaded value is kept in the local layer too.
+func (n *NearCache[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) (value T, err error) {
+	if value, found := n.getLocal(key); found {
+		n.localHit.Add(1)
+		return value, nil
+	}
+	n.localMiss.Add(1)
+
+	var loaded atomic.Bool //? The loader can also run in background (stale refresh)
+	value, err = n.cache.GetOrLoad(ctx, key, ttl, func(ctx context.Context) (T, error) {
+		loaded.Store(true)
+		return loader(ctx)
+	})
+	if err != nil {
+		return
+	}
+	if loaded.Load() {
+		n.remoteMiss.Add(1)
+	} else {
+		n.remoteHit.Add(1)
+	}
+	n.setLocal(key, value)
+	return
+}
+
+// ? Write to Redis then drop the local copy of every pod.
+func (n *NearCache[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) (err error) {
+	if err = n.cache.Set(ctx, key, value, ttl); err != nil {
+		return
+	}
+	n.invalidateLocal(false, key)
+	return n.publish(ctx, false, key)
+}
+
+func (n *NearCache[T]) Delete(ctx context.Context, listKey ...string) (err error) {
+	if err = n.cache.Delete(ctx, listKey...); err != nil {
+		return
+	}
+	n.invalidateLocal(false, listKey...)
+	return n.publish(ctx, false, listKey...)
+}
+
+// ? Drop the local copy of every pod without touching Redis, for a value written by another way.
+// ? No key = everything.
+func (n *NearCache[T]) Invalidate(ctx context.Context, listKey ...string) (err error) {
+	n.invalidateLocal(len(listKey) == 0, listKey...)
+	return n.publish(ctx, len(listKey) == 0, listKey...)
+}
+
+func (n *NearCache[T]) Stats() NearCacheStats {
+	return NearCacheStats{
+		LocalHit:     n.localHit.Load(),
+		LocalMiss:    n.localMiss.Load(),
+		RemoteHit:    n.remoteHit.Load(),
+		RemoteMiss:   n.remoteMiss.Load(),
+		Eviction:     n.eviction.Load(),
+		Invalidation: n.invalidation.Load(),
+	}
+}
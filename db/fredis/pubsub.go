@@ -0,0 +1,103 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+// ? v is always sent as json, receive it with Subscribe[T]. The key prefix is applied to the channel.
+func (r *Redis) Publish(ctx context.Context, channel string, v any) (err error) {
+	payload, err := JSONCodec.Marshal(v)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	channel = r.prefixKey(channel)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.publish: channel=%s", channel)))
+	if err = r.open().Publish(ctx, channel, payload).Err(); err != nil {
+		log.Println(channel, aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? channel is without the key prefix.
+type SubscribeHandler[T any] func(ctx context.Context, channel string, value T) error
+
+// ? Block until ctx is done. A message that can't be decoded or a handler error is logged then skipped,
+// ? a lost connection is retried with backoff and the channels are subscribed again.
+// ? e.g. go fredis.Subscribe(ctx, rds, func(ctx context.Context, channel string, ev OrderEvent) error {...}, "order")
+func Subscribe[T any](ctx context.Context, r *Redis, handler SubscribeHandler[T], channels ...string) (err error) {
+	if len(channels) == 0 {
+		return errors.New("channel can't empty")
+	}
+
+	listChannel := make([]string, len(channels))
+	for i, channel := range channels {
+		listChannel[i] = r.prefixKey(channel)
+	}
+	pubsub := r.open().Subscribe(ctx, listChannel...)
+	defer pubsub.Close()
+
+	wait, subscribed := reconnectMinWait, false
+	for {
+		var msg *redis.Message
+		if subscribed {
+			msg, err = pubsub.ReceiveMessage(ctx)
+		} else { //? Wait for the subscription confirmation
+			_, err = pubsub.Receive(ctx)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil { //? go-redis reconnect and subscribe again on the next receive
+			log.Println(aurora.Red(err))
+			if !SleepCtx(ctx, wait) {
+				return nil
+			}
+			wait = min(wait*2, reconnectMaxWait)
+			continue
+		}
+		wait = reconnectMinWait
+		if !subscribed {
+			subscribed = true
+			log.Println(aurora.BrightRed(fmt.Sprintf("redis.subscribe: channel=%s", strings.Join(listChannel, ","))))
+			continue
+		}
+
+		var value T
+		if err = JSONCodec.Unmarshal([]byte(msg.Payload), &value); err != nil {
+			log.Println(msg.Channel, aurora.Red(err))
+			continue
+		}
+		channel := strings.TrimPrefix(msg.Channel, r.prefixKey(""))
+		if err = handler(ctx, channel, value); err != nil {
+			log.Println(msg.Channel, aurora.Red(err))
+		}
+	}
+}
+
+const (
+	reconnectMinWait = 100 * time.Millisecond
+	reconnectMaxWait = 5 * time.Second
+)
+
+// ? Sleep d, false when ctx is done before d.
+func SleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
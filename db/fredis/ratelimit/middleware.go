@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/logrusorgru/aurora"
+)
+
+type KeyFunc func(r *http.Request) string
+
+// ? The peer address only, the forwarded headers can be set by any client. Behind a proxy use KeyByForwardedIP.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ? X-Forwarded-For / X-Real-IP, only when the request comes from one of trustedProxies (IP or CIDR).
+// ? X-Forwarded-For is read from the right, the first address that isn't a trusted proxy is the client.
+// ? e.g. ratelimit.Middleware(limiter, ratelimit.KeyByForwardedIP("10.0.0.0/8"))
+func KeyByForwardedIP(trustedProxies ...string) KeyFunc {
+	listPrefix := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, errAddr := netip.ParseAddr(proxy)
+			if errAddr != nil {
+				log.Println("trusted proxy", proxy, aurora.Red(err))
+				continue
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		listPrefix = append(listPrefix, prefix.Masked())
+	}
+	isTrusted := func(ip string) bool {
+		addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, prefix := range listPrefix {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		remote := KeyByIP(r)
+		if !isTrusted(remote) {
+			return remote
+		}
+
+		if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+			listIP := strings.Split(strings.Join(forwardedFor, ","), ",")
+			for i := len(listIP) - 1; i >= 0; i-- {
+				if ip := strings.TrimSpace(listIP[i]); ip != "" && !isTrusted(ip) {
+					return ip
+				}
+			}
+			if first := strings.TrimSpace(listIP[0]); first != "" { //? Every hop is a trusted proxy
+				return first
+			}
+		}
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+		return remote
+	}
+}
+
+// ? Reply 429 when the limit is reached. When Redis is down the request is allowed (fail open).
+// ? keyFunc nil = KeyByIP, empty key skip the limiter.
+func Middleware(limiter Limiter, keyFunc KeyFunc) func(http.Handler) http.Handler {
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				log.Println(aurora.Red(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+			header.Set("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+			header.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(res.ResetAfter.Seconds())), 10))
+			if !res.Allowed {
+				header.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(res.RetryAfter.Seconds())), 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
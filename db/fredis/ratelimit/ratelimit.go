@@ -0,0 +1,229 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dansbeer/go-forge/db/fredis"
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	KEY_FOLDER = "ratelimit"
+)
+
+type Result struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	RetryAfter time.Duration //? 0 when allowed
+	ResetAfter time.Duration //? Until the limit is fully restored
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+	AllowN(ctx context.Context, key string, n int64) (Result, error)
+}
+
+func toDuration(value any) time.Duration {
+	switch asType := value.(type) {
+	case int64:
+		return time.Duration(asType) * time.Millisecond
+	case string: //? Float from lua is returned as string to keep the precision
+		asFloat, _ := strconv.ParseFloat(asType, 64)
+		return time.Duration(asFloat * float64(time.Millisecond))
+	}
+	return 0
+}
+
+func run(ctx context.Context, rds *fredis.Redis, script *redis.Script, key string, args ...any) (res []any, err error) {
+	client := rds.GetUniversalClient()
+	if client == nil {
+		return nil, fmt.Errorf("redis client is not available")
+	}
+	if res, err = script.Run(ctx, client, []string{key}, args...).Slice(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                FIXED WINDOW                                */
+/* -------------------------------------------------------------------------- */
+
+// ? The window start on the first request, then reset after `window`.
+var fixedWindowScript = redis.NewScript(`
+local n, limit, window = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if current + n > limit then
+	local ttl = redis.call("PTTL", KEYS[1])
+	if ttl < 0 then -- no window yet, e.g. n is greater than limit
+		ttl = window
+	end
+	return {0, current, ttl}
+end
+current = redis.call("INCRBY", KEYS[1], n)
+if current == n then
+	redis.call("PEXPIRE", KEYS[1], window)
+end
+return {1, current, redis.call("PTTL", KEYS[1])}`)
+
+type FixedWindow struct {
+	redis  *fredis.Redis
+	limit  int64
+	window time.Duration
+}
+
+func NewFixedWindow(rds *fredis.Redis, limit int64, window time.Duration) *FixedWindow {
+	return &FixedWindow{redis: rds, limit: limit, window: window}
+}
+
+func (l *FixedWindow) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *FixedWindow) AllowN(ctx context.Context, key string, n int64) (res Result, err error) {
+	raw, err := run(ctx, l.redis, fixedWindowScript, l.redis.BuildKey(KEY_FOLDER, "fixed", key),
+		n, l.limit, l.window.Milliseconds())
+	if err != nil {
+		return
+	}
+
+	current, resetAfter := raw[1].(int64), toDuration(raw[2])
+	res = Result{
+		Allowed:    raw[0].(int64) == 1,
+		Limit:      l.limit,
+		Remaining:  max(l.limit-current, 0),
+		ResetAfter: max(resetAfter, 0),
+	}
+	if !res.Allowed {
+		res.RetryAfter = res.ResetAfter
+	}
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                             SLIDING WINDOW LOG                             */
+/* -------------------------------------------------------------------------- */
+
+// ? Every request is a member of a sorted set scored by its time (redis server time, in ms).
+var slidingWindowLogScript = redis.NewScript(`
+local n, limit, window, id = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), ARGV[4]
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + n > limit then
+	local retryAfter = window
+	local oldest = redis.call("ZRANGE", KEYS[1], count + n - limit - 1, count + n - limit - 1, "WITHSCORES")
+	if oldest[2] then
+		retryAfter = tonumber(oldest[2]) + window - now
+	end
+	return {0, count, retryAfter}
+end
+
+for i = 1, n do
+	redis.call("ZADD", KEYS[1], now, id .. ":" .. i)
+end
+redis.call("PEXPIRE", KEYS[1], window)
+return {1, count + n, 0}`)
+
+type SlidingWindowLog struct {
+	redis  *fredis.Redis
+	limit  int64
+	window time.Duration
+}
+
+// ? Exact but use memory per request, prefer FixedWindow / TokenBucket for high limit.
+func NewSlidingWindowLog(rds *fredis.Redis, limit int64, window time.Duration) *SlidingWindowLog {
+	return &SlidingWindowLog{redis: rds, limit: limit, window: window}
+}
+
+func (l *SlidingWindowLog) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *SlidingWindowLog) AllowN(ctx context.Context, key string, n int64) (res Result, err error) {
+	raw, err := run(ctx, l.redis, slidingWindowLogScript, l.redis.BuildKey(KEY_FOLDER, "sliding", key),
+		n, l.limit, l.window.Milliseconds(), fid.GenerateID())
+	if err != nil {
+		return
+	}
+
+	count := raw[1].(int64)
+	res = Result{
+		Allowed:    raw[0].(int64) == 1,
+		Limit:      l.limit,
+		Remaining:  max(l.limit-count, 0),
+		RetryAfter: max(toDuration(raw[2]), 0),
+		ResetAfter: l.window,
+	}
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                             TOKEN BUCKET (GCRA)                            */
+/* -------------------------------------------------------------------------- */
+
+// ? GCRA: only the theoretical arrival time (tat) is stored, in ms of redis server time.
+var gcraScript = redis.NewScript(`
+local n, burst, emission = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + tonumber(time[2]) / 1000
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+tat = math.max(tat, now)
+local newTat = tat + emission * n
+local diff = now - (newTat - emission * burst)
+if diff < 0 then
+	return {0, 0, tostring(-diff), tostring(tat - now)}
+end
+
+local resetAfter = newTat - now
+redis.call("SET", KEYS[1], tostring(newTat), "PX", math.ceil(resetAfter))
+return {1, math.floor(diff / emission), "0", tostring(resetAfter)}`)
+
+type TokenBucket struct {
+	redis    *fredis.Redis
+	emission time.Duration
+	burst    int64
+}
+
+// ? rate token every period, with bucket size burst. e.g. NewTokenBucket(rds, 10, time.Second, 20)
+// ? rate <= 0 is clamped to 1.
+func NewTokenBucket(rds *fredis.Redis, rate int64, period time.Duration, burst int64) *TokenBucket {
+	rate = max(rate, 1)
+	if burst <= 0 {
+		burst = rate
+	}
+	return &TokenBucket{redis: rds, emission: max(period/time.Duration(rate), 1), burst: burst}
+}
+
+func (l *TokenBucket) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *TokenBucket) AllowN(ctx context.Context, key string, n int64) (res Result, err error) {
+	emission := float64(l.emission) / float64(time.Millisecond)
+	raw, err := run(ctx, l.redis, gcraScript, l.redis.BuildKey(KEY_FOLDER, "gcra", key),
+		n, l.burst, strconv.FormatFloat(emission, 'f', -1, 64))
+	if err != nil {
+		return
+	}
+
+	res = Result{
+		Allowed:    raw[0].(int64) == 1,
+		Limit:      l.burst,
+		Remaining:  raw[1].(int64),
+		RetryAfter: max(toDuration(raw[2]), 0),
+		ResetAfter: max(toDuration(raw[3]), 0),
+	}
+	return
+}
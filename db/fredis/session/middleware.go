@@ -0,0 +1,112 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/logrusorgru/aurora"
+)
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// ? Set by Manager.Middleware.
+func FromContext(ctx context.Context) (res *Session, found bool) {
+	res, found = ctx.Value(contextKey{}).(*Session)
+	return
+}
+
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.option.CookieName,
+		Value:    value,
+		Path:     m.option.CookiePath,
+		Domain:   m.option.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   !m.option.Insecure,
+		HttpOnly: true,
+		SameSite: m.option.SameSite,
+	}
+}
+
+// ? Load the session of the cookie (or a new one) into the request context, then save it and set the cookie
+// ? before the response is written. A new session is only stored when it's changed.
+// ? e.g. http.ListenAndServe(":8080", manager.Middleware(mux)), then session.FromContext(r.Context()) in the handler
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.load(r)
+		sw := &sessionWriter{ResponseWriter: w}
+		sw.beforeWrite = func() {
+			m.commit(r.Context(), w, s)
+		}
+		next.ServeHTTP(sw, r.WithContext(NewContext(r.Context(), s)))
+		sw.commit()
+	})
+}
+
+func (m *Manager) load(r *http.Request) *Session {
+	cookie, err := r.Cookie(m.option.CookieName)
+	if err != nil {
+		return m.New()
+	}
+	s, err := m.Get(r.Context(), cookie.Value)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) { //? Redis is down, continue as anonymous
+			log.Println(aurora.Red(err))
+		}
+		return m.New()
+	}
+	return s
+}
+
+func (m *Manager) commit(ctx context.Context, w http.ResponseWriter, s *Session) {
+	s.mutex.RLock()
+	destroyed, untouched := s.destroyed, s.isNew && !s.changed
+	s.mutex.RUnlock()
+	if untouched && !destroyed {
+		return
+	}
+
+	if err := m.Save(context.WithoutCancel(ctx), s); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+	if destroyed {
+		http.SetCookie(w, m.cookie("", -1))
+		return
+	}
+	http.SetCookie(w, m.cookie(s.ID, int(m.option.TTL.Seconds())))
+}
+
+// ? The cookie must be set before the header is written.
+type sessionWriter struct {
+	http.ResponseWriter
+	beforeWrite func()
+	committed   bool
+}
+
+func (w *sessionWriter) commit() {
+	if !w.committed {
+		w.committed = true
+		w.beforeWrite()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(statusCode int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.commit()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,329 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dansbeer/go-forge/db/fredis"
+	"github.com/dansbeer/go-forge/fstring/fid"
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	KEY_FOLDER = "session"
+
+	fieldUserID    = "_user_id"
+	fieldCreatedAt = "_created_at"
+	fieldValue     = "v:"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+// ? Safe for concurrent use. Changes are written by Manager.Save (or the middleware after the handler).
+type Session struct {
+	ID        string
+	UserID    string //? Empty = anonymous
+	CreatedAt time.Time
+
+	mutex          sync.RWMutex
+	values         map[string]string
+	removed        map[string]bool
+	previousUserID string
+	isNew          bool
+	changed        bool
+	userChanged    bool
+	destroyed      bool
+}
+
+func (s *Session) Get(key string) (value string, found bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	value, found = s.values[key]
+	return
+}
+
+// ? Copy of every value.
+func (s *Session) Values() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return maps.Clone(s.values)
+}
+
+func (s *Session) Set(key, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[key], s.changed = value, true
+	delete(s.removed, key)
+}
+
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.values, key)
+	s.removed[key], s.changed = true, true
+}
+
+// ? Attach the session to a user, the ID is regenerated on save (session fixation).
+func (s *Session) Login(userID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.userChanged {
+		s.previousUserID = s.UserID
+	}
+	s.UserID, s.userChanged, s.changed = userID, true, true
+}
+
+// ? Removed on save, the middleware also clear the cookie.
+func (s *Session) Destroy() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.destroyed = true
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                   MANAGER                                  */
+/* -------------------------------------------------------------------------- */
+
+type Option struct {
+	TTL          time.Duration //? Idle timeout, extended on every load (sliding expiration). Default 24h
+	CookieName   string        //? Default "sid"
+	CookiePath   string        //? Default "/"
+	CookieDomain string
+	Insecure     bool          //? Allow the cookie over plain http (local development)
+	SameSite     http.SameSite //? Default Lax
+}
+
+func (o Option) withDefault() Option {
+	if o.TTL <= 0 {
+		o.TTL = 24 * time.Hour
+	}
+	if o.CookieName == "" {
+		o.CookieName = "sid"
+	}
+	if o.CookiePath == "" {
+		o.CookiePath = "/"
+	}
+	if o.SameSite == 0 {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	return o
+}
+
+// ? Session data is a Redis hash session:<id>, the sessions of a user are indexed in the set session:user:<user id>.
+// ? e.g. manager := session.NewManager(rds, session.Option{TTL: 7 * 24 * time.Hour})
+type Manager struct {
+	redis  *fredis.Redis
+	option Option
+}
+
+func NewManager(rds *fredis.Redis, option Option) *Manager {
+	return &Manager{redis: rds, option: option.withDefault()}
+}
+
+func (m *Manager) sessionKey(id string) string {
+	return fredis.BuildKey(KEY_FOLDER, id)
+}
+
+func (m *Manager) userKey(userID string) string {
+	return fredis.BuildKey(KEY_FOLDER, "user", userID)
+}
+
+// ? Not stored until Save.
+func (m *Manager) New() *Session {
+	return &Session{
+		ID:        fid.GenerateID(),
+		CreatedAt: time.Now(),
+		values:    make(map[string]string),
+		removed:   make(map[string]bool),
+		isNew:     true,
+	}
+}
+
+// ? Create and store a session of userID.
+func (m *Manager) Create(ctx context.Context, userID string) (res *Session, err error) {
+	res = m.New()
+	res.UserID, res.changed = userID, true
+	if err = m.Save(ctx, res); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// ? Load the session and extend its expiration. ErrNotFound when it doesn't exist or expired.
+func (m *Manager) Get(ctx context.Context, id string) (res *Session, err error) {
+	if id == "" {
+		return nil, ErrNotFound
+	}
+	var hash map[string]string
+	found, err := m.redis.HGetAll(ctx, m.sessionKey(id), &hash)
+	if err != nil {
+		return
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	res = fromHash(id, hash)
+	m.touch(ctx, res)
+	return
+}
+
+func fromHash(id string, hash map[string]string) (res *Session) {
+	res = &Session{ID: id, UserID: hash[fieldUserID], values: make(map[string]string), removed: make(map[string]bool)}
+	createdAt, _ := strconv.ParseInt(hash[fieldCreatedAt], 10, 64)
+	res.CreatedAt = time.UnixMilli(createdAt)
+	for field, value := range hash {
+		if key, isValue := strings.CutPrefix(field, fieldValue); isValue {
+			res.values[key] = value
+		}
+	}
+	return
+}
+
+func (m *Manager) touch(ctx context.Context, s *Session) {
+	m.redis.Expire(ctx, m.sessionKey(s.ID), m.option.TTL)
+	if s.UserID != "" {
+		m.redis.Expire(ctx, m.userKey(s.UserID), m.option.TTL)
+	}
+}
+
+// ? Write the changes (or delete a destroyed session). Nothing is written for an untouched session.
+func (m *Manager) Save(ctx context.Context, s *Session) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.destroyed {
+		return m.destroy(ctx, s.ID, s.UserID, s.previousUserID)
+	}
+	if !s.changed {
+		return
+	}
+
+	previousID := ""
+	if s.userChanged && !s.isNew {
+		previousID, s.ID = s.ID, fid.GenerateID()
+	}
+
+	hash := map[string]string{fieldUserID: s.UserID, fieldCreatedAt: strconv.FormatInt(s.CreatedAt.UnixMilli(), 10)}
+	for key, value := range s.values {
+		hash[fieldValue+key] = value
+	}
+	key := m.sessionKey(s.ID)
+	if err = m.redis.HSet(ctx, key, hash, m.option.TTL); err != nil {
+		return
+	}
+	if previousID == "" && len(s.removed) > 0 {
+		listField := make([]string, 0, len(s.removed))
+		for removed := range s.removed {
+			listField = append(listField, fieldValue+removed)
+		}
+		if _, err = m.redis.HDel(ctx, key, listField...); err != nil {
+			return
+		}
+	}
+
+	if s.UserID != "" {
+		if _, err = m.redis.SAdd(ctx, m.userKey(s.UserID), s.ID); err != nil {
+			return
+		}
+		m.redis.Expire(ctx, m.userKey(s.UserID), m.option.TTL)
+	}
+	if previousID != "" {
+		if err = m.destroy(ctx, previousID, s.previousUserID); err != nil {
+			return
+		}
+	}
+
+	s.isNew, s.changed, s.userChanged, s.previousUserID = false, false, false, ""
+	s.removed = make(map[string]bool)
+	return
+}
+
+func (m *Manager) destroy(ctx context.Context, id string, listUserID ...string) (err error) {
+	if _, err = m.redis.DeleteMany(ctx, m.sessionKey(id)); err != nil {
+		return
+	}
+	for _, userID := range listUserID {
+		if userID == "" {
+			continue
+		}
+		if _, err = m.redis.SRem(ctx, m.userKey(userID), id); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (m *Manager) Destroy(ctx context.Context, id string) (err error) {
+	s, err := m.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	return m.destroy(ctx, id, s.UserID)
+}
+
+// ? Active sessions of a user, the expired ones are removed from the index.
+// ? Read only, unlike Get the expiration of the sessions is not extended.
+func (m *Manager) ListUser(ctx context.Context, userID string) (res []*Session, err error) {
+	listID, err := m.redis.SMembers(ctx, m.userKey(userID))
+	if err != nil || len(listID) == 0 {
+		return
+	}
+
+	listCmd := make([]*redis.MapStringStringCmd, len(listID))
+	if _, err = m.redis.GetUniversalClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range listID {
+			listCmd[i] = pipe.HGetAll(ctx, m.redis.BuildKey(m.sessionKey(id)))
+		}
+		return nil
+	}); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	var listExpired []any
+	for i, id := range listID {
+		hash := listCmd[i].Val()
+		if len(hash) == 0 {
+			listExpired = append(listExpired, id)
+			continue
+		}
+		res = append(res, fromHash(id, hash))
+	}
+	if len(listExpired) > 0 {
+		m.redis.SRem(ctx, m.userKey(userID), listExpired...)
+	}
+	return
+}
+
+// ? "Log out everywhere", return the number of deleted sessions.
+func (m *Manager) DestroyUser(ctx context.Context, userID string) (deleted int64, err error) {
+	listID, err := m.redis.SMembers(ctx, m.userKey(userID))
+	if err != nil {
+		return
+	}
+
+	listKey := make([]string, 0, len(listID))
+	for _, id := range listID {
+		listKey = append(listKey, m.sessionKey(id))
+	}
+	if deleted, err = m.redis.DeleteMany(ctx, listKey...); err != nil {
+		return
+	}
+	if _, err = m.redis.DeleteMany(ctx, m.userKey(userID)); err != nil {
+		return
+	}
+	log.Println(aurora.BrightRed("session.destroy_user: user=" + userID))
+	return
+}
@@ -0,0 +1,112 @@
+package fredis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+)
+
+// ? Members are written like Set: native value as is, the rest as json.
+func encodeListValue(listValue []any) (res []any, err error) {
+	res = make([]any, len(listValue))
+	for i, value := range listValue {
+		if res[i], err = encodeValue(value); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (r *Redis) SAdd(ctx context.Context, key string, members ...any) (added int64, err error) {
+	listMember, err := encodeListValue(members)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.sadd: key=%s", key)))
+	if added, err = r.open().SAdd(ctx, key, listMember...).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) SRem(ctx context.Context, key string, members ...any) (removed int64, err error) {
+	listMember, err := encodeListValue(members)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.srem: key=%s", key)))
+	if removed, err = r.open().SRem(ctx, key, listMember...).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) SIsMember(ctx context.Context, key string, member any) (res bool, err error) {
+	if member, err = encodeValue(member); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.sismember: key=%s", aurora.BrightBlue(key))))
+	if res, err = r.open().SIsMember(ctx, key, member).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? Whole set at once, for a big set prefer SIsMember / SCard.
+func (r *Redis) SMembers(ctx context.Context, key string) (res []string, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.smembers: key=%s", aurora.BrightBlue(key))))
+	if res, err = r.open().SMembers(ctx, key).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) SCard(ctx context.Context, key string) (res int64, err error) {
+	key = r.prefixKey(key)
+	if res, err = r.open().SCard(ctx, key).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                     TTL                                    */
+/* -------------------------------------------------------------------------- */
+
+func (r *Redis) Expire(ctx context.Context, key string, ttl time.Duration) (found bool, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.expire: key=%s ttl=%s", key, ttl)))
+	if found, err = r.open().Expire(ctx, key, ttl).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? -1 = no expiration, -2 = the key doesn't exist (go-redis convention).
+func (r *Redis) TTL(ctx context.Context, key string) (res time.Duration, err error) {
+	key = r.prefixKey(key)
+	if res, err = r.open().PTTL(ctx, key).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
@@ -0,0 +1,267 @@
+package fredis
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// ? Return ErrNotFound from the loader to cache the "not found" result (LoadOption.NegativeTTL).
+var ErrNotFound = errors.New("not found")
+
+type LoadOption struct {
+	LockTTL     time.Duration //? Cluster wide loader lock, default 5s
+	WaitTimeout time.Duration //? How long the other instances wait for the lock owner, default LockTTL. After that they call the loader themselves
+	StaleTTL    time.Duration //? Keep serving the old value this long after ttl while one instance refresh it in background
+	Beta        float64       //? XFetch probabilistic early refresh, 0 = disabled, 1 = recommended, > 1 refresh earlier
+	NegativeTTL time.Duration //? Cache ErrNotFound this long, 0 = disabled
+	Tags        []string      //? The loaded value is also deleted by InvalidateTags
+	Refresh     bool          //? Ignore the cached value and call the loader, even when another instance is loading
+}
+
+func (o LoadOption) withDefault() LoadOption {
+	if o.LockTTL <= 0 {
+		o.LockTTL = 5 * time.Second
+	}
+	if o.WaitTimeout <= 0 {
+		o.WaitTimeout = o.LockTTL
+	}
+	return o
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                  ENVELOPE                                  */
+/* -------------------------------------------------------------------------- */
+
+// ? magic | expireAt (unix milli) | delta (milli) | flag | payload
+var envelopeMagic = []byte("fx1\x00")
+
+const (
+	envelopeHeaderSize = 4 + 8 + 8 + 1
+	envelopeNegative   = 1
+)
+
+type envelope struct {
+	expireAt time.Time
+	delta    time.Duration //? How long the loader took, used by XFetch
+	negative bool
+	payload  []byte
+}
+
+func (e envelope) encode() []byte {
+	res := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(e.payload))
+	copy(res, envelopeMagic)
+	binary.BigEndian.PutUint64(res[4:], uint64(e.expireAt.UnixMilli()))
+	binary.BigEndian.PutUint64(res[12:], uint64(e.delta.Milliseconds()))
+	if e.negative {
+		res[20] = envelopeNegative
+	}
+	return append(res, e.payload...)
+}
+
+// ? Plain value (written by Set) is returned as a never expiring envelope.
+func decodeEnvelope(raw []byte) (res envelope) {
+	if len(raw) < envelopeHeaderSize || !bytes.Equal(raw[:4], envelopeMagic) {
+		return envelope{payload: raw}
+	}
+	return envelope{
+		expireAt: time.UnixMilli(int64(binary.BigEndian.Uint64(raw[4:]))),
+		delta:    time.Duration(binary.BigEndian.Uint64(raw[12:])) * time.Millisecond,
+		negative: raw[20] == envelopeNegative,
+		payload:  raw[envelopeHeaderSize:],
+	}
+}
+
+func (e envelope) isExpired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// ? XFetch: the closer to expireAt and the slower the loader, the higher the chance to refresh early.
+func (e envelope) shouldRefreshEarly(now time.Time, beta float64) bool {
+	if beta <= 0 || e.expireAt.IsZero() || e.delta <= 0 {
+		return false
+	}
+	gap := time.Duration(float64(e.delta) * beta * -math.Log(1-rand.Float64()))
+	return !now.Add(gap).Before(e.expireAt)
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                 GET OR LOAD                                */
+/* -------------------------------------------------------------------------- */
+
+var loadGroup singleflight.Group
+
+// ? Only one loader run per key: singleflight inside the process and a short Redis lock across the cluster.
+// ? The value is stored in an envelope, read it back with GetOrLoad / Cache.Get, not Redis.Get.
+func (r *Redis) GetOrLoad(ctx context.Context, key string, ttl time.Duration, opt LoadOption,
+	ptrDecodeTo any, loader func(ctx context.Context) (any, error),
+) (err error) {
+	payload, err := r.getOrLoadBytes(ctx, key, ttl, opt, func(ctx context.Context) ([]byte, error) {
+		value, err := loader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return JSONCodec.Marshal(value)
+	})
+	if err != nil {
+		return
+	}
+	return JSONCodec.Unmarshal(payload, ptrDecodeTo)
+}
+
+func (r *Redis) getOrLoadBytes(ctx context.Context, key string, ttl time.Duration, opt LoadOption,
+	loader func(ctx context.Context) ([]byte, error),
+) (payload []byte, err error) {
+	opt = opt.withDefault()
+	fullKey := r.prefixKey(key)
+
+	var raw []byte
+	if !opt.Refresh {
+		raw, err = r.open().Get(ctx, fullKey).Bytes()
+	}
+	switch {
+	case opt.Refresh, errors.Is(err, redis.Nil):
+	case err != nil: //? Redis is down, don't let it take the service down too.
+		log.Println(fullKey, aurora.Red(err))
+		return loader(ctx)
+	default:
+		cached, now := decodeEnvelope(raw), time.Now()
+		if cached.isExpired(now) || cached.shouldRefreshEarly(now, opt.Beta) {
+			r.refreshInBackground(ctx, key, ttl, opt, loader)
+		}
+		if cached.negative {
+			return nil, ErrNotFound
+		}
+		return cached.payload, nil
+	}
+
+	groupKey := fullKey
+	if opt.Refresh { //? Don't join a load that may end up returning the cached value
+		groupKey = "reload" + FOLDER_DELIMITER + fullKey
+	}
+	//? The shared load outlives any single caller, each caller only stop waiting on its own ctx.
+	loadCtx := context.WithoutCancel(ctx)
+	loading := loadGroup.DoChan(groupKey, func() (any, error) {
+		return r.loadWithLock(loadCtx, key, ttl, opt, loader, true)
+	})
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case res := <-loading:
+		if err = res.Err; err != nil {
+			return
+		}
+		return res.Val.([]byte), nil
+	}
+}
+
+func (r *Redis) refreshInBackground(ctx context.Context, key string, ttl time.Duration, opt LoadOption,
+	loader func(ctx context.Context) ([]byte, error),
+) {
+	ctx = context.WithoutCancel(ctx)
+	fullKey := r.prefixKey(key)
+	go loadGroup.Do("refresh"+FOLDER_DELIMITER+fullKey, func() (any, error) {
+		return r.loadWithLock(ctx, key, ttl, opt, loader, false)
+	})
+}
+
+func (r *Redis) loadWithLock(ctx context.Context, key string, ttl time.Duration, opt LoadOption,
+	loader func(ctx context.Context) ([]byte, error), wait bool,
+) (payload []byte, err error) {
+	fullKey := r.prefixKey(key)
+	lockKey := r.prefixKey(BuildKey(LOCK_FOLDER, "load", key))
+
+	token, acquired, err := r.tryLock(ctx, lockKey, opt.LockTTL)
+	if err != nil {
+		return loader(ctx)
+	}
+	if !acquired {
+		if !wait { //? Another instance is refreshing
+			return
+		}
+		if opt.Refresh { //? The owner may have started before the change, waiting would return its old value
+			return r.load(ctx, key, ttl, opt, loader)
+		}
+		if payload, err = r.waitLoaded(ctx, fullKey, opt.WaitTimeout); err == nil || errors.Is(err, ErrNotFound) {
+			return
+		}
+		log.Println(fullKey, aurora.Yellow("wait loader timeout, load locally"))
+		return loader(ctx)
+	}
+	defer r.releaseLock(context.WithoutCancel(ctx), lockKey, token)
+	return r.load(ctx, key, ttl, opt, loader)
+}
+
+// ? Call the loader and store its result.
+func (r *Redis) load(ctx context.Context, key string, ttl time.Duration, opt LoadOption,
+	loader func(ctx context.Context) ([]byte, error),
+) (payload []byte, err error) {
+	fullKey := r.prefixKey(key)
+	var versions []string
+	if len(opt.Tags) > 0 {
+		if versions, err = r.tagVersions(ctx, opt.Tags); err != nil {
+			return loader(ctx) //? Not stored, an invalidation couldn't be detected
+		}
+	}
+
+	start := time.Now()
+	payload, err = loader(ctx)
+	cached := envelope{expireAt: time.Now().Add(ttl), delta: time.Since(start), payload: payload}
+	physicalTTL := ttl + opt.StaleTTL
+	if ttl <= 0 {
+		cached.expireAt, physicalTTL = time.Time{}, 0
+	}
+	switch {
+	case errors.Is(err, ErrNotFound) && opt.NegativeTTL > 0:
+		cached.negative, cached.payload = true, nil
+		cached.expireAt, physicalTTL = time.Now().Add(opt.NegativeTTL), opt.NegativeTTL
+	case err != nil:
+		return
+	}
+
+	if len(opt.Tags) > 0 {
+		r.setWithTags(ctx, fullKey, cached.encode(), physicalTTL, opt.Tags, versions) //? Refused when a tag was invalidated meanwhile
+		return
+	}
+	if errSet := r.open().Set(ctx, fullKey, cached.encode(), physicalTTL).Err(); errSet != nil {
+		log.Println(fullKey, aurora.Red(errSet))
+	}
+	return
+}
+
+func (r *Redis) waitLoaded(ctx context.Context, fullKey string, timeout time.Duration) (payload []byte, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	interval := 20 * time.Millisecond
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		raw, errGet := r.open().Get(ctx, fullKey).Bytes()
+		if errGet == nil {
+			cached := decodeEnvelope(raw)
+			if cached.negative {
+				return nil, ErrNotFound
+			}
+			return cached.payload, nil
+		}
+		if !errors.Is(errGet, redis.Nil) {
+			return nil, errGet
+		}
+		interval = min(interval*2, 200*time.Millisecond)
+	}
+}
@@ -0,0 +1,286 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	STREAM_FIELD_DATA       = "data"
+	STREAM_FIELD_SOURCE_ID  = "source_id"
+	STREAM_FIELD_DELIVERIES = "deliveries"
+	STREAM_FIELD_ERROR      = "error"
+	STREAM_DEAD_SUFFIX      = "dead"
+)
+
+type StreamMessage[T any] struct {
+	ID         string
+	Value      T
+	Deliveries int64 //? 1 on the first delivery, more when reclaimed from a failed consumer
+}
+
+// ? Work queue on a Redis stream, every message is handled by one consumer of the group.
+// ? e.g. stream := fredis.NewStream[Invoice](rds, "invoice")
+type Stream[T any] struct {
+	redis  *Redis
+	name   string
+	maxLen int64
+}
+
+func NewStream[T any](r *Redis, name string) *Stream[T] {
+	return &Stream[T]{redis: r, name: name}
+}
+
+// ? Approximate trimming on every Add, 0 = unlimited.
+func (s *Stream[T]) SetMaxLen(maxLen int64) *Stream[T] {
+	s.maxLen = maxLen
+	return s
+}
+
+// ? Messages that failed MaxDeliveries times or can't be decoded, consume it with the same type.
+func (s *Stream[T]) DeadLetter() *Stream[T] {
+	return NewStream[T](s.redis, BuildKey(s.name, STREAM_DEAD_SUFFIX))
+}
+
+func (s *Stream[T]) key() string {
+	return s.redis.prefixKey(s.name)
+}
+
+func (s *Stream[T]) Add(ctx context.Context, value T) (id string, err error) {
+	payload, err := JSONCodec.Marshal(value)
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+	return s.add(ctx, map[string]any{STREAM_FIELD_DATA: payload})
+}
+
+func (s *Stream[T]) add(ctx context.Context, values map[string]any) (id string, err error) {
+	key := s.key()
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.xadd: key=%s", key)))
+	id, err = s.redis.open().XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: s.maxLen,
+		Approx: s.maxLen > 0,
+		Values: values,
+	}).Result()
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? Pending = delivered but not acknowledged yet.
+func (s *Stream[T]) Pending(ctx context.Context, group string) (res int64, err error) {
+	pending, err := s.redis.open().XPending(ctx, s.key(), group).Result()
+	if err != nil {
+		log.Println(s.key(), aurora.Red(err))
+		return
+	}
+	return pending.Count, nil
+}
+
+type ConsumerOption struct {
+	Group         string
+	Consumer      string        //? Default hostname-pid, must be unique inside the group
+	Count         int64         //? Messages per read, default 10
+	Block         time.Duration //? Default 5s
+	MinIdle       time.Duration //? Pending message idle this long is reclaimed by another consumer, default 1m
+	MaxDeliveries int64         //? Moved to the dead letter stream after this many deliveries, default 5
+	StartID       string        //? When the group is created, default "0" (every message in the stream), "$" = new messages only
+}
+
+func (o ConsumerOption) withDefault() ConsumerOption {
+	if o.Consumer == "" {
+		hostname, _ := os.Hostname()
+		o.Consumer = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if o.Count <= 0 {
+		o.Count = 10
+	}
+	if o.Block <= 0 {
+		o.Block = 5 * time.Second
+	}
+	if o.MinIdle <= 0 {
+		o.MinIdle = time.Minute
+	}
+	if o.MaxDeliveries <= 0 {
+		o.MaxDeliveries = 5
+	}
+	if o.StartID == "" {
+		o.StartID = "0"
+	}
+	return o
+}
+
+func (s *Stream[T]) createGroup(ctx context.Context, opt ConsumerOption) (err error) {
+	err = s.redis.open().XGroupCreateMkStream(ctx, s.key(), opt.Group, opt.StartID).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return
+}
+
+// ? Block until ctx is done. The message is acknowledged when handler return nil,
+// ? otherwise it stay pending and is delivered again after MinIdle (to any consumer of the group).
+func (s *Stream[T]) Consume(ctx context.Context, opt ConsumerOption, handler func(ctx context.Context, msg StreamMessage[T]) error) (err error) {
+	if opt.Group == "" {
+		return errors.New("group can't empty")
+	}
+	opt = opt.withDefault()
+	if err = s.createGroup(ctx, opt); err != nil {
+		log.Println(s.key(), aurora.Red(err))
+		return
+	}
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.consume: key=%s group=%s consumer=%s", s.key(), opt.Group, opt.Consumer)))
+
+	claimCursor, wait := "0-0", reconnectMinWait
+	for ctx.Err() == nil {
+		var listMessage []StreamMessage[T]
+		listMessage, claimCursor, err = s.reclaim(ctx, opt, claimCursor)
+		if err == nil && len(listMessage) == 0 {
+			listMessage, err = s.read(ctx, opt)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Println(s.key(), aurora.Red(err))
+			if !SleepCtx(ctx, wait) {
+				break
+			}
+			wait = min(wait*2, reconnectMaxWait)
+			continue
+		}
+		wait = reconnectMinWait
+
+		for _, msg := range listMessage {
+			if errHandler := handler(ctx, msg); errHandler != nil {
+				log.Println(s.key(), msg.ID, aurora.Red(errHandler))
+				continue
+			}
+			s.ack(ctx, opt.Group, msg.ID)
+		}
+	}
+	return nil
+}
+
+func (s *Stream[T]) read(ctx context.Context, opt ConsumerOption) (res []StreamMessage[T], err error) {
+	listStream, err := s.redis.open().XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    opt.Group,
+		Consumer: opt.Consumer,
+		Streams:  []string{s.key(), ">"},
+		Count:    opt.Count,
+		Block:    opt.Block,
+	}).Result()
+	if errors.Is(err, redis.Nil) { //? Nothing new before Block
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, stream := range listStream {
+		for _, raw := range stream.Messages {
+			if msg, ok := s.decode(ctx, opt, raw, 1); ok {
+				res = append(res, msg)
+			}
+		}
+	}
+	return
+}
+
+// ? XAUTOCLAIM the messages idle more than MinIdle, the ones delivered too many times go to the dead letter.
+func (s *Stream[T]) reclaim(ctx context.Context, opt ConsumerOption, cursor string) (res []StreamMessage[T], nextCursor string, err error) {
+	rdb := s.redis.open()
+	listRaw, nextCursor, err := rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.key(),
+		Group:    opt.Group,
+		MinIdle:  opt.MinIdle,
+		Start:    cursor,
+		Count:    opt.Count,
+		Consumer: opt.Consumer,
+	}).Result()
+	if err != nil {
+		return nil, cursor, err
+	}
+	if len(listRaw) == 0 {
+		return
+	}
+
+	//? One XPENDING per ID, a range over the claimed IDs could return other pending entries first
+	listCmd := make([]*redis.XPendingExtCmd, len(listRaw))
+	if _, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, raw := range listRaw {
+			listCmd[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: s.key(),
+				Group:  opt.Group,
+				Start:  raw.ID,
+				End:    raw.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, cursor, err
+	}
+	deliveries := make(map[string]int64, len(listRaw))
+	for _, cmd := range listCmd {
+		for _, pending := range cmd.Val() {
+			deliveries[pending.ID] = pending.RetryCount
+		}
+	}
+
+	for _, raw := range listRaw {
+		if deliveries[raw.ID] > opt.MaxDeliveries {
+			s.deadLetter(ctx, opt, raw, deliveries[raw.ID], "max deliveries reached")
+			continue
+		}
+		if msg, ok := s.decode(ctx, opt, raw, deliveries[raw.ID]); ok {
+			res = append(res, msg)
+		}
+	}
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.xautoclaim: key=%s claimed=%d", s.key(), len(listRaw))))
+	return
+}
+
+// ? A message that can't be decoded will never succeed, it goes straight to the dead letter.
+func (s *Stream[T]) decode(ctx context.Context, opt ConsumerOption, raw redis.XMessage, deliveries int64) (res StreamMessage[T], ok bool) {
+	data, _ := raw.Values[STREAM_FIELD_DATA].(string)
+	res = StreamMessage[T]{ID: raw.ID, Deliveries: deliveries}
+	if err := JSONCodec.Unmarshal([]byte(data), &res.Value); err != nil {
+		log.Println(s.key(), raw.ID, aurora.Red(err))
+		s.deadLetter(ctx, opt, raw, deliveries, err.Error())
+		return
+	}
+	return res, true
+}
+
+func (s *Stream[T]) deadLetter(ctx context.Context, opt ConsumerOption, raw redis.XMessage, deliveries int64, reason string) {
+	_, err := s.DeadLetter().add(ctx, map[string]any{
+		STREAM_FIELD_DATA:       raw.Values[STREAM_FIELD_DATA],
+		STREAM_FIELD_SOURCE_ID:  raw.ID,
+		STREAM_FIELD_DELIVERIES: strconv.FormatInt(deliveries, 10),
+		STREAM_FIELD_ERROR:      reason,
+	})
+	if err != nil { //? Keep it pending, retried on the next reclaim
+		return
+	}
+	s.ack(ctx, opt.Group, raw.ID)
+}
+
+func (s *Stream[T]) ack(ctx context.Context, group, id string) {
+	if err := s.redis.open().XAck(ctx, s.key(), group, id).Err(); err != nil {
+		log.Println(s.key(), id, aurora.Red(err))
+	}
+}
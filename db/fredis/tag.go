@@ -0,0 +1,165 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	TAG_FOLDER = "tag"
+
+	tagPruneRate   = 50 //? 1 of tagPruneRate tagged Set also prune the tags
+	tagPruneSample = 20
+
+	tagVersionFolder = "tag_version"
+	tagVersionTTL    = 24 * time.Hour //? Longer than any load
+)
+
+// ? SET + SADD to every tag set. The tag set live as long as its longest key (persist when a key has no ttl).
+// ? ARGV[3] = "1" also remove a sample of members that don't exist anymore (expired / deleted).
+// ? KEYS[1] the key, then ARGV[5] tags, then optionally their version keys: nothing is written when
+// ? a version isn't ARGV[5 + i] anymore (the tag was invalidated after the value was read).
+var tagSetScript = redis.NewScript(`
+local ttl, tagCount = tonumber(ARGV[2]), tonumber(ARGV[5])
+if #KEYS > tagCount + 1 then
+	for i = 1, tagCount do
+		if (redis.call("GET", KEYS[tagCount + 1 + i]) or "0") ~= ARGV[5 + i] then
+			return 0
+		end
+	end
+end
+
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+
+for i = 2, tagCount + 1 do
+	local existed = redis.call("EXISTS", KEYS[i])
+	redis.call("SADD", KEYS[i], KEYS[1])
+	if ttl == 0 then
+		redis.call("PERSIST", KEYS[i])
+	else
+		local tagTTL = redis.call("PTTL", KEYS[i])
+		if existed == 0 or (tagTTL >= 0 and tagTTL < ttl) then
+			redis.call("PEXPIRE", KEYS[i], ttl)
+		end
+	end
+
+	if ARGV[3] == "1" then
+		for _, member in ipairs(redis.call("SRANDMEMBER", KEYS[i], tonumber(ARGV[4]))) do
+			if redis.call("EXISTS", member) == 0 then
+				redis.call("SREM", KEYS[i], member)
+			end
+		end
+	end
+end
+return 1`)
+
+// ? KEYS the tag sets then their version keys, the version is incremented.
+var invalidateTagsScript = redis.NewScript(`
+local deleted, tagCount = 0, #KEYS / 2
+for i = 1, tagCount do
+	local members = redis.call("SMEMBERS", KEYS[i])
+	for j = 1, #members, 500 do
+		deleted = deleted + redis.call("UNLINK", unpack(members, j, math.min(j + 499, #members)))
+	end
+	redis.call("UNLINK", KEYS[i])
+	redis.call("INCR", KEYS[tagCount + i])
+	redis.call("PEXPIRE", KEYS[tagCount + i], ARGV[1])
+end
+return deleted`)
+
+func (r *Redis) tagKey(tag string) string {
+	return r.prefixKey(BuildKey(TAG_FOLDER, tag))
+}
+
+// ? Incremented by InvalidateTags.
+func (r *Redis) tagVersionKey(tag string) string {
+	return r.prefixKey(BuildKey(tagVersionFolder, tag))
+}
+
+// ? Read before loading a value, then given to setWithTags.
+func (r *Redis) tagVersions(ctx context.Context, tags []string) (res []string, err error) {
+	listCmd := make([]*redis.StringCmd, len(tags))
+	if _, err = r.open().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, tag := range tags {
+			listCmd[i] = pipe.Get(ctx, r.tagVersionKey(tag))
+		}
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		log.Println(aurora.Red(err))
+		return
+	}
+
+	res = make([]string, len(tags))
+	for i, cmd := range listCmd {
+		if res[i] = cmd.Val(); cmd.Err() != nil {
+			res[i] = "0"
+		}
+	}
+	return res, nil
+}
+
+// ? fullKey is already prefixed, value already encoded. versions from tagVersions, nil = always written.
+// ? In cluster mode the key and its tags must share a hash tag, e.g. key "{outlet}:list:..." with tag "{outlet}:42".
+func (r *Redis) setWithTags(ctx context.Context, fullKey string, value any, ttl time.Duration, tags, versions []string) (err error) {
+	keys := make([]string, 0, 2*len(tags)+1)
+	keys = append(keys, fullKey)
+	for _, tag := range tags {
+		keys = append(keys, r.tagKey(tag))
+	}
+	prune := "0"
+	if rand.Intn(tagPruneRate) == 0 {
+		prune = "1"
+	}
+	args := []any{value, ttl.Milliseconds(), prune, tagPruneSample, len(tags)}
+	if versions != nil {
+		for i, tag := range tags {
+			keys, args = append(keys, r.tagVersionKey(tag)), append(args, versions[i])
+		}
+	}
+
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.set: key=%s tag=%s", fullKey, strings.Join(tags, ","))))
+	written, err := tagSetScript.Run(ctx, r.open(), keys, args...).Int64()
+	if err != nil {
+		log.Println(fullKey, aurora.Red(err))
+		return
+	}
+	if written == 0 {
+		log.Println(fullKey, aurora.Yellow("not written, tag invalidated during the load"))
+	}
+	return
+}
+
+// ? Delete every key Set with one of the tags, and the tags, in one atomic script.
+// ? e.g. rds.Set(key, outlets, time.Hour, "outlet:42") then rds.InvalidateTags(ctx, "outlet:42")
+func (r *Redis) InvalidateTags(ctx context.Context, tags ...string) (deleted int64, err error) {
+	if len(tags) == 0 {
+		return
+	}
+	keys := make([]string, 2*len(tags))
+	for i, tag := range tags {
+		keys[i], keys[len(tags)+i] = r.tagKey(tag), r.tagVersionKey(tag)
+	}
+
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.invalidate: tag=%s", strings.Join(keys[:len(tags)], ","))))
+	if deleted, err = invalidateTagsScript.Run(ctx, r.open(), keys, tagVersionTTL.Milliseconds()).Int64(); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (c *Cache[T]) InvalidateTags(ctx context.Context, tags ...string) (deleted int64, err error) {
+	return c.redis.InvalidateTags(ctx, tags...)
+}
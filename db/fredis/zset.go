@@ -0,0 +1,168 @@
+package fredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/redis/go-redis/v9"
+)
+
+// ? Rank start from 0, in the order of the query (desc = highest score first, like a leaderboard).
+type ZMember struct {
+	Member string
+	Score  float64
+	Rank   int64
+}
+
+func (r *Redis) ZAdd(ctx context.Context, key, member string, score float64) (err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.zadd: key=%s member=%s", key, member)))
+	if err = r.open().ZAdd(ctx, key, redis.Z{Score: score, Member: member}).Err(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? Return the new score, the member is added when it doesn't exist.
+func (r *Redis) ZIncrBy(ctx context.Context, key, member string, delta float64) (score float64, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.zincrby: key=%s member=%s", key, member)))
+	if score, err = r.open().ZIncrBy(ctx, key, delta, member).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) ZRem(ctx context.Context, key string, members ...string) (removed int64, err error) {
+	listMember := make([]any, len(members))
+	for i, member := range members {
+		listMember[i] = member
+	}
+
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.zrem: key=%s", key)))
+	if removed, err = r.open().ZRem(ctx, key, listMember...).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func (r *Redis) ZScore(ctx context.Context, key, member string) (score float64, found bool, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.zscore: key=%s member=%s", aurora.BrightBlue(key), member)))
+	score, err = r.open().ZScore(ctx, key, member).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return score, true, nil
+}
+
+// ? desc = rank by highest score first.
+func (r *Redis) ZRank(ctx context.Context, key, member string, desc bool) (res ZMember, found bool, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.zrank: key=%s member=%s", aurora.BrightBlue(key), member)))
+	var rank *redis.IntCmd
+	var score *redis.FloatCmd
+	_, err = r.open().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if desc {
+			rank = pipe.ZRevRank(ctx, key, member)
+		} else {
+			rank = pipe.ZRank(ctx, key, member)
+		}
+		score = pipe.ZScore(ctx, key, member)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return res, false, nil
+	}
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return ZMember{Member: member, Score: score.Val(), Rank: rank.Val()}, true, nil
+}
+
+// ? start & stop are rank, inclusive, -1 = last. e.g. top 10: rds.ZRange(ctx, "score", 0, 9, true)
+func (r *Redis) ZRange(ctx context.Context, key string, start, stop int64, desc bool) (res []ZMember, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.zrange: key=%s", aurora.BrightBlue(key))))
+	listZ, err := r.open().ZRangeArgsWithScores(ctx, redis.ZRangeArgs{Key: key, Start: start, Stop: stop, Rev: desc}).Result()
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+
+	firstRank := start
+	if start < 0 { //? Negative index, the rank need the set size
+		count, err := r.open().ZCard(ctx, key).Result()
+		if err != nil {
+			log.Println(key, aurora.Red(err))
+			return nil, err
+		}
+		firstRank = max(count+start, 0)
+	}
+	return toZMember(listZ, firstRank), nil
+}
+
+// ? The members ranked around member (radius before and after), for "your position" in a leaderboard.
+func (r *Redis) ZAround(ctx context.Context, key, member string, radius int64, desc bool) (res []ZMember, err error) {
+	rank, found, err := r.ZRank(ctx, key, member, desc)
+	if err != nil || !found {
+		return
+	}
+	return r.ZRange(ctx, key, max(rank.Rank-radius, 0), rank.Rank+radius, desc)
+}
+
+// ? minScore & maxScore inclusive, count 0 = no limit. Rank is the position inside the result.
+func (r *Redis) ZRangeByScore(ctx context.Context, key string, minScore, maxScore float64, offset, count int64, desc bool) (res []ZMember, err error) {
+	key = r.prefixKey(key)
+	log.Println(aurora.BrightRed(fmt.Sprintf("redis.zrangebyscore: key=%s", aurora.BrightBlue(key))))
+	args := redis.ZRangeArgs{
+		Key:     key,
+		Start:   strconv.FormatFloat(minScore, 'f', -1, 64),
+		Stop:    strconv.FormatFloat(maxScore, 'f', -1, 64),
+		ByScore: true,
+		Rev:     desc,
+		Offset:  offset,
+		Count:   count,
+	}
+	if count <= 0 && offset > 0 {
+		args.Count = -1
+	}
+
+	listZ, err := r.open().ZRangeArgsWithScores(ctx, args).Result()
+	if err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return toZMember(listZ, offset), nil
+}
+
+func (r *Redis) ZCard(ctx context.Context, key string) (res int64, err error) {
+	key = r.prefixKey(key)
+	if res, err = r.open().ZCard(ctx, key).Result(); err != nil {
+		log.Println(key, aurora.Red(err))
+		return
+	}
+	return
+}
+
+func toZMember(listZ []redis.Z, firstRank int64) (res []ZMember) {
+	res = make([]ZMember, len(listZ))
+	for i, z := range listZ {
+		member, _ := z.Member.(string)
+		res[i] = ZMember{Member: member, Score: z.Score, Rank: firstRank + int64(i)}
+	}
+	return
+}
@@ -0,0 +1,215 @@
+package fsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dansbeer/go-forge/db/fredis"
+	"github.com/logrusorgru/aurora"
+)
+
+const (
+	CACHE_FOLDER = "fsql"
+)
+
+type CachePolicy struct {
+	TTL         time.Duration //? Default 6h
+	Namespace   string        //? Key prefix, default "fsql". Key = <namespace>:<connection type>:<sha256 of the DSN, query and args>
+	NegativeTTL time.Duration //? Cache an empty result this long, 0 = an empty result is cached with TTL like the others
+	StaleTTL    time.Duration //? Keep serving the old result this long after TTL while it's refreshed in background
+	Tables      []string      //? Tables read by the query, evicted by an Insert into one of them. Empty = parsed from FROM / JOIN
+	Disable     bool
+}
+
+func (p CachePolicy) withDefault() CachePolicy {
+	if p.TTL <= 0 {
+		p.TTL = 6 * time.Hour
+	}
+	if p.Namespace == "" {
+		p.Namespace = CACHE_FOLDER
+	}
+	return p
+}
+
+type cacheMode int
+
+const (
+	cacheMode_Default cacheMode = iota
+	cacheMode_Bypass
+	cacheMode_Refresh
+)
+
+type (
+	cacheModeKey   struct{}
+	cachePolicyKey struct{}
+)
+
+// ? Read from the database without reading nor writing the cache.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, cacheMode_Bypass)
+}
+
+// ? Read from the database and replace the cached result.
+func WithCacheRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, cacheMode_Refresh)
+}
+
+// ? Policy of the queries made with ctx, replace the policy of the SQLConn.
+// ? e.g. sc.Query(fsql.WithCachePolicy(ctx, fsql.CachePolicy{TTL: time.Minute}), query, args...)
+func WithCachePolicy(ctx context.Context, policy CachePolicy) context.Context {
+	return context.WithValue(ctx, cachePolicyKey{}, policy)
+}
+
+func (sc *SQLConn) SetCachePolicy(policy CachePolicy) *SQLConn {
+	sc.cachePolicy = policy
+	return sc
+}
+
+func (sc *SQLConn) policyOf(ctx context.Context) CachePolicy {
+	if policy, found := ctx.Value(cachePolicyKey{}).(CachePolicy); found {
+		return policy.withDefault()
+	}
+	return sc.cachePolicy.withDefault()
+}
+
+// ? Shared by every query of the SQLConn, opened on the first cached query.
+func (sc *SQLConn) cacheRedis() (res *fredis.Redis, err error) {
+	sc.redisMutex.Lock()
+	defer sc.redisMutex.Unlock()
+	if sc.redisConn == nil {
+		if sc.redisConn, err = fredis.NewRedisCacheConnection(); err != nil {
+			return
+		}
+	}
+	return sc.redisConn, nil
+}
+
+// ? The same query on another database (or server) is another entry.
+func (sc *SQLConn) cacheKey(policy CachePolicy, query string, args []any) string {
+	sum := sha256.Sum256([]byte(sc.connectionKey() + "__" + cacheKeyOf(query, args)))
+	return fredis.BuildKey(policy.Namespace, sc.ConnectionType.String(), hex.EncodeToString(sum[:]))
+}
+
+// ? By connectionKey rather than Db, which is empty for NewSQLConnFromDSN.
+func (sc *SQLConn) tableTag(policy CachePolicy, table string) string {
+	return fredis.BuildKey(policy.Namespace, "table", sc.ConnectionType.String(), sc.connectionKey()[:16], normalizeTable(table))
+}
+
+var tablePattern = regexp.MustCompile(`(?i)\b(?:from|join)\s+([\w."` + "`" + `]+)`)
+
+// ? Best effort, a table read in a sub query written another way is missed: set CachePolicy.Tables.
+func parseTables(query string) (res []string) {
+	found := map[string]bool{}
+	for _, match := range tablePattern.FindAllStringSubmatch(query, -1) {
+		table := normalizeTable(match[1])
+		if table != "" && !found[table] {
+			found[table] = true
+			res = append(res, table)
+		}
+	}
+	return
+}
+
+// ? schema."Outlet" -> outlet
+func normalizeTable(table string) string {
+	if index := strings.LastIndex(table, "."); index >= 0 {
+		table = table[index+1:]
+	}
+	return strings.ToLower(strings.Trim(table, "\"`"))
+}
+
+// ? ptrDecodeTo is filled from the cache, or from the database through fredis GetOrLoad
+// ? (one loader per key across the cluster, stale while revalidate, negative cache).
+func (sc *SQLConn) cachedScan(ctx context.Context, ptrDecodeTo any, query string, args []any) (err error) {
+	policy, mode := sc.policyOf(ctx), ctx.Value(cacheModeKey{})
+	if sc.disableCache || policy.Disable || mode == cacheMode_Bypass {
+		return sc.rawScan(ctx, ptrDecodeTo, query, args)
+	}
+
+	redis, err := sc.cacheRedis()
+	if err != nil {
+		log.Println("Redis connection error:", err)
+		return sc.rawScan(ctx, ptrDecodeTo, query, args)
+	}
+
+	listTable := policy.Tables
+	if len(listTable) == 0 {
+		listTable = parseTables(query)
+	}
+	loadOption := fredis.LoadOption{
+		StaleTTL:    policy.StaleTTL,
+		NegativeTTL: policy.NegativeTTL,
+		Refresh:     mode == cacheMode_Refresh,
+	}
+	for _, table := range listTable {
+		loadOption.Tags = append(loadOption.Tags, sc.tableTag(policy, table))
+	}
+
+	var loaded atomic.Bool //? The loader can also run in background (stale refresh)
+	resultType := reflect.TypeOf(ptrDecodeTo).Elem()
+	err = redis.GetOrLoad(ctx, sc.cacheKey(policy, query, args), policy.TTL, loadOption, ptrDecodeTo, func(ctx context.Context) (any, error) {
+		loaded.Store(true)
+		result := reflect.New(resultType) //? Not ptrDecodeTo, a background refresh run after the caller returned
+		if err := sc.rawScan(ctx, result.Interface(), query, args); err != nil {
+			return nil, err
+		}
+		if policy.NegativeTTL > 0 && isEmpty(result.Elem()) {
+			return nil, fredis.ErrNotFound
+		}
+		return result.Interface(), nil
+	})
+	if errors.Is(err, fredis.ErrNotFound) { //? The same empty result as a database read, not what ptrDecodeTo held
+		reflect.ValueOf(ptrDecodeTo).Elem().Set(emptyResult(resultType))
+		return nil
+	}
+	if err == nil && !loaded.Load() {
+		fmt.Println(aurora.Red("CACHED"))
+	}
+	return
+}
+
+// ? gorm Scan leave an empty (not nil) slice when there is no row.
+func emptyResult(resultType reflect.Type) reflect.Value {
+	switch resultType.Kind() {
+	case reflect.Slice:
+		return reflect.MakeSlice(resultType, 0, 0)
+	case reflect.Map:
+		return reflect.MakeMap(resultType)
+	}
+	return reflect.Zero(resultType)
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// ? Delete the cached reads of the tables, done by Insert. For a write made another way.
+// ? The tags are under the Namespace of the policy of ctx, the same as the queries that read the tables.
+func (sc *SQLConn) InvalidateTables(ctx context.Context, listTable ...string) (deleted int64, err error) {
+	if len(listTable) == 0 {
+		return
+	}
+	redis, err := sc.cacheRedis()
+	if err != nil {
+		return
+	}
+	policy := sc.policyOf(ctx)
+	listTag := make([]string, len(listTable))
+	for i, table := range listTable {
+		listTag[i] = sc.tableTag(policy, table)
+	}
+	return redis.InvalidateTags(ctx, listTag...)
+}
@@ -0,0 +1,159 @@
+package fsql
+
+import (
+	"log"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/logrusorgru/aurora"
+)
+
+type DSNOption struct {
+	SSLMode  string            //? Postgres sslmode (disable, require, verify-full...), MySQL tls (true, skip-verify...), SQL Server encrypt, ClickHouse secure
+	TimeZone string            //? e.g. Asia/Jakarta. Postgres TimeZone, MySQL loc
+	Params   map[string]string //? Extra driver parameters, win over the ones above
+}
+
+// ? <PREFIX>_SSL_MODE, <PREFIX>_TIMEZONE and <PREFIX>_PARAMS (query string, e.g. "connect_timeout=5&application_name=api")
+func dsnOptionFromEnv(prefix string) (res DSNOption) {
+	res.SSLMode, res.TimeZone = os.Getenv(prefix+"_SSL_MODE"), os.Getenv(prefix+"_TIMEZONE")
+	if raw := os.Getenv(prefix + "_PARAMS"); raw != "" {
+		values, err := url.ParseQuery(raw)
+		if err != nil {
+			log.Println(prefix+"_PARAMS", aurora.Red(err))
+			return
+		}
+		res.Params = make(map[string]string, len(values))
+		for key := range values {
+			res.Params[key] = values.Get(key)
+		}
+	}
+	return
+}
+
+func firstDSNOption(listOption []DSNOption) DSNOption {
+	if len(listOption) == 0 {
+		return DSNOption{}
+	}
+	return listOption[0]
+}
+
+// ? host, host:port or [ipv6]:port
+func splitHostPort(host, defaultPort string) (string, string) {
+	if hostname, port, err := net.SplitHostPort(host); err == nil {
+		return hostname, port
+	}
+	return strings.Trim(host, "[]"), defaultPort
+}
+
+// ? params plus option.Params, in a stable order.
+func dsnQuery(params map[string]string, option DSNOption) string {
+	listParam := maps.Clone(params)
+	if listParam == nil {
+		listParam = make(map[string]string)
+	}
+	maps.Copy(listParam, option.Params)
+
+	values := url.Values{}
+	for key, value := range listParam {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	return values.Encode()
+}
+
+func clickHouseDSN(host, db, user, pwd string, option DSNOption) string {
+	params := map[string]string{"read_timeout": "30s"}
+	if option.SSLMode != "" && option.SSLMode != "disable" && option.SSLMode != "false" {
+		params["secure"] = "true"
+	}
+	if option.SSLMode == "skip-verify" {
+		params["skip_verify"] = "true"
+	}
+	dsn := url.URL{Scheme: "clickhouse", User: url.UserPassword(user, pwd), Host: host, Path: "/" + db, RawQuery: dsnQuery(params, option)}
+	return dsn.String()
+}
+
+// ? key=value form, the value is quoted when needed.
+func postgreDSN(host, db, user, pwd string, option DSNOption) string {
+	hostname, port := splitHostPort(host, "5432")
+	params := map[string]string{
+		"host": hostname, "port": port, "user": user, "password": pwd, "dbname": db,
+		"sslmode": option.SSLMode, "TimeZone": option.TimeZone,
+	}
+	maps.Copy(params, option.Params)
+
+	listPair := make([]string, 0, len(params))
+	for _, key := range slices.Sorted(maps.Keys(params)) {
+		if params[key] != "" {
+			listPair = append(listPair, key+"="+postgreQuote(params[key]))
+		}
+	}
+	return strings.Join(listPair, " ")
+}
+
+func postgreQuote(value string) string {
+	if !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// ? option.Params parseTime, loc and tls win over the fields, the others are passed as is (e.g. timeout, collation).
+func mySQLDSN(host, db, user, pwd string, option DSNOption) string {
+	hostname, port := splitHostPort(host, "3306")
+	config := mysql.NewConfig()
+	config.User, config.Passwd, config.DBName = user, pwd, db
+	config.Net, config.Addr = "tcp", net.JoinHostPort(hostname, port)
+	config.ParseTime, config.Loc, config.TLSConfig = true, time.Local, option.SSLMode
+	config.Params = map[string]string{"charset": "utf8mb4"}
+
+	timeZone := option.TimeZone
+	for key, value := range option.Params {
+		switch key {
+		case "parseTime":
+			config.ParseTime = value == "true" || value == "1"
+		case "loc":
+			timeZone = value
+		case "tls":
+			config.TLSConfig = value
+		default:
+			config.Params[key] = value
+		}
+	}
+	if timeZone != "" {
+		loc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			log.Println("mysql loc", aurora.Red(err))
+		} else {
+			config.Loc = loc
+		}
+	}
+	return config.FormatDSN()
+}
+
+func sqlServerDSN(host, db, user, pwd string, option DSNOption) string {
+	hostname, port := splitHostPort(host, "1433")
+	params := map[string]string{"database": db, "encrypt": option.SSLMode}
+	dsn := url.URL{Scheme: "sqlserver", User: url.UserPassword(user, pwd), Host: net.JoinHostPort(hostname, port), RawQuery: dsnQuery(params, option)}
+	return dsn.String()
+}
+
+// ? path is a file or ":memory:", option.Params are appended (e.g. "_pragma": "foreign_keys(1)").
+func sqliteDSN(path string, option DSNOption) string {
+	query := dsnQuery(nil, option)
+	if query == "" {
+		return path
+	}
+	if strings.Contains(path, "?") {
+		return path + "&" + query
+	}
+	return path + "?" + query
+}
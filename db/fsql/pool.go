@@ -0,0 +1,289 @@
+package fsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dansbeer/go-forge/db/fredis"
+	"github.com/glebarez/sqlite"
+	"github.com/logrusorgru/aurora"
+	"gorm.io/driver/clickhouse"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type PoolOption struct {
+	MaxOpenConns    int           //? Default 10
+	MaxIdleConns    int           //? Default 5
+	ConnMaxLifetime time.Duration //? Default 30m
+	ConnMaxIdleTime time.Duration //? Default 5m
+	MaxRetry        int           //? Retry of a failed open, default 3
+	RetryBackoff    time.Duration //? Doubled on every retry, default 500ms
+}
+
+func (o PoolOption) withDefault() PoolOption {
+	if o.MaxOpenConns <= 0 {
+		o.MaxOpenConns = 10
+	}
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = 5
+	}
+	if o.ConnMaxLifetime <= 0 {
+		o.ConnMaxLifetime = 30 * time.Minute
+	}
+	if o.ConnMaxIdleTime <= 0 {
+		o.ConnMaxIdleTime = 5 * time.Minute
+	}
+	if o.MaxRetry <= 0 {
+		o.MaxRetry = 3
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 500 * time.Millisecond
+	}
+	return o
+}
+
+type sharedDB struct {
+	key      string
+	db       *gorm.DB
+	refCount int //? Number of SQLConn using the pool
+	closed   bool
+}
+
+var (
+	listDB map[string]*sharedDB //? By connectionKey, every SQLConn with the same DSN share one pool
+	mutex  sync.RWMutex
+)
+
+func init() {
+	listDB = make(map[string]*sharedDB)
+}
+
+// ? The password is part of the dsn, hashed with the rest.
+func (sc *SQLConn) connectionKey() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q", []any{sc.ConnectionType.String(), sc.dsn})))
+	return hex.EncodeToString(sum[:])
+}
+
+func (sc *SQLConn) getDB() (res *gorm.DB) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if sc.shared != nil && !sc.shared.closed {
+		res = sc.shared.db
+	}
+	return
+}
+
+// ? The pool of the DSN, opened on the first use. Don't close the returned db, use SQLConn.Close.
+func (sc *SQLConn) connect(ctx context.Context) (db *gorm.DB, err error) {
+	return sc.connectWithRetry(ctx, true)
+}
+
+// ? retry false = a single open attempt, e.g. a replica falling back to the primary.
+func (sc *SQLConn) connectWithRetry(ctx context.Context, retry bool) (db *gorm.DB, err error) {
+	if db = sc.getDB(); db != nil {
+		return
+	}
+
+	key := sc.connectionKey()
+	mutex.Lock()
+	if shared := listDB[key]; shared != nil {
+		if sc.shared != shared { //? Opened by another SQLConn
+			shared.refCount++
+			sc.shared = shared
+		}
+		mutex.Unlock()
+		return shared.db, nil
+	}
+	mutex.Unlock()
+
+	//? Opened without the lock, the retries would block every other connection
+	opened, err := sc.openWithRetry(ctx, retry)
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	shared := listDB[key]
+	if shared == nil {
+		shared = &sharedDB{key: key, db: opened}
+		listDB[key] = shared
+		opened = nil
+	}
+	shared.refCount++
+	sc.shared = shared
+	mutex.Unlock()
+
+	if opened != nil { //? Another goroutine was faster
+		closeDB(opened)
+	}
+	return shared.db, nil
+}
+
+// ? Only connection errors are retried, a wrong password or an unknown database would fail again.
+func (sc *SQLConn) openWithRetry(ctx context.Context, retry bool) (db *gorm.DB, err error) {
+	option := sc.pool.withDefault()
+	if !retry {
+		option.MaxRetry = 0
+	}
+	wait := option.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if db, err = sc.open(option); err == nil {
+			log.Println(aurora.BrightRed(fmt.Sprintf("sql.open: %s %s", sc.ConnectionType, sc.Host)))
+			return
+		}
+		log.Println(sc.ConnectionType, sc.Host, aurora.Red(err))
+		if attempt >= option.MaxRetry || !isConnectionError(err) {
+			return nil, fmt.Errorf("%s open after %d attempt: %w", sc.ConnectionType, attempt+1, err)
+		}
+		if !fredis.SleepCtx(ctx, wait) {
+			return nil, fmt.Errorf("%s open after %d attempt: %w", sc.ConnectionType, attempt+1, errors.Join(err, ctx.Err()))
+		}
+		wait *= 2
+	}
+}
+
+// ? Network errors and timeouts, e.g. the server is restarting. Some drivers only keep the message.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, pattern := range []string{"connection refused", "connection reset", "i/o timeout", "broken pipe", "no such host"} {
+		if strings.Contains(message, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sc *SQLConn) open(option PoolOption) (db *gorm.DB, err error) {
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Info)}
+	switch sc.ConnectionType {
+	case SQLConn_Type_Clickhouse:
+		db, err = gorm.Open(clickhouse.Open(sc.dsn), config)
+	case SQLConn_Type_Postgre:
+		db, err = gorm.Open(postgres.Open(sc.dsn), config)
+	case SQLConn_Type_MySQL:
+		db, err = gorm.Open(mysql.Open(sc.dsn), config)
+	case SQLConn_Type_SQLite:
+		db, err = gorm.Open(sqlite.Open(sc.dsn), config)
+	case SQLConn_Type_SQLServer:
+		db, err = gorm.Open(sqlserver.Open(sc.dsn), config)
+	default:
+		err = fmt.Errorf("unknown connection type %d", sc.ConnectionType)
+	}
+	if err != nil {
+		return
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return
+	}
+	sqlDB.SetMaxOpenConns(option.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(option.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(option.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(option.ConnMaxIdleTime)
+	if sc.ConnectionType == SQLConn_Type_SQLite && strings.Contains(sc.dsn, ":memory:") { //? Every connection is another database
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+	}
+	return
+}
+
+func closeDB(db *gorm.DB) (err error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+	if err = sqlDB.Close(); err != nil {
+		log.Println(aurora.Red(err))
+		return
+	}
+	return
+}
+
+// ? Release the pool of this SQLConn (and its replicas), the pool is closed when the last SQLConn sharing it is closed.
+// ? Using the SQLConn after Close open the pool again.
+func (sc *SQLConn) Close() (err error) {
+	var listErr []error
+	for _, r := range sc.listReplica {
+		if err := r.conn.Close(); err != nil {
+			listErr = append(listErr, err)
+		}
+	}
+	if err = sc.close(); err != nil {
+		listErr = append(listErr, err)
+	}
+	return errors.Join(listErr...)
+}
+
+func (sc *SQLConn) close() (err error) {
+	mutex.Lock()
+	shared := sc.shared
+	sc.shared = nil
+	if shared == nil || shared.closed {
+		mutex.Unlock()
+		return
+	}
+	if shared.refCount--; shared.refCount > 0 {
+		mutex.Unlock()
+		return
+	}
+	shared.closed = true
+	delete(listDB, shared.key)
+	mutex.Unlock()
+
+	log.Println(aurora.BrightRed(fmt.Sprintf("sql.close: %s %s", sc.ConnectionType, sc.Host)))
+	return closeDB(shared.db)
+}
+
+// ? Close every pool of the process, for graceful shutdown.
+// ? e.g. defer fsql.CloseAll(context.Background())
+func CloseAll(ctx context.Context) (err error) {
+	mutex.Lock()
+	listShared := listDB
+	listDB = make(map[string]*sharedDB)
+	for _, shared := range listShared {
+		shared.closed = true
+	}
+	mutex.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		var listErr []error
+		for _, shared := range listShared {
+			if err := closeDB(shared.db); err != nil {
+				listErr = append(listErr, err)
+			}
+		}
+		done <- errors.Join(listErr...)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-done:
+		log.Println(aurora.BrightRed(fmt.Sprintf("sql.close: %d pool", len(listShared))))
+		return
+	}
+}
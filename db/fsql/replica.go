@@ -0,0 +1,107 @@
+package fsql
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"gorm.io/gorm"
+)
+
+type ReplicaOption struct {
+	Downtime time.Duration //? A failing replica is skipped this long, default 30s
+}
+
+func (o ReplicaOption) withDefault() ReplicaOption {
+	if o.Downtime <= 0 {
+		o.Downtime = 30 * time.Second
+	}
+	return o
+}
+
+type replica struct {
+	conn      *SQLConn
+	downUntil atomic.Int64 //? Unix milli
+}
+
+func (r *replica) isHealthy(now time.Time) bool {
+	return now.UnixMilli() >= r.downUntil.Load()
+}
+
+type primaryKey struct{}
+
+// ? Read from the primary, e.g. right after a write the replicas may not have yet.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func isPrimary(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryKey{}).(bool)
+	return forced
+}
+
+// ? Reads (BaseExec, Exec, Query, GetCount) are balanced across the healthy replicas, Insert and WithPrimary use sc.
+// ? The cache policy and the Redis of the primary are used for every read, each replica keep its own PoolOption.
+// ? The health is passive, there is no background ping: a replica is marked down by a failed read (its open is not
+// ? retried, the read fall back to the primary at once) then skipped for ReplicaOption.Downtime.
+// ? e.g. fsql.NewPostgreFromEnv().SetReplica(fsql.BaseNewPostgreFromEnv(replicaHost, db, user, pwd))
+func (sc *SQLConn) SetReplica(listReplica ...*SQLConn) *SQLConn {
+	sc.listReplica = make([]*replica, 0, len(listReplica))
+	for _, conn := range listReplica {
+		sc.listReplica = append(sc.listReplica, &replica{conn: conn})
+	}
+	return sc
+}
+
+func (sc *SQLConn) SetReplicaOption(option ReplicaOption) *SQLConn {
+	sc.replicaOption = option
+	return sc
+}
+
+// ? Round robin from the next replica, nil when there is none healthy.
+func (sc *SQLConn) nextReplica() *replica {
+	count := len(sc.listReplica)
+	if count == 0 {
+		return nil
+	}
+	now, start := time.Now(), sc.replicaIndex.Add(1)
+	for i := range count {
+		r := sc.listReplica[(start+uint64(i))%uint64(count)]
+		if r.isHealthy(now) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (sc *SQLConn) markDown(r *replica, err error) {
+	downtime := sc.replicaOption.withDefault().Downtime
+	r.downUntil.Store(time.Now().Add(downtime).UnixMilli())
+	log.Println("replica down:", r.conn.Host, aurora.Red(err))
+}
+
+// ? Run read on a healthy replica, or on the primary when there is none, it's forced, or the replica fails to connect.
+// ? Only a connection error is retried on the primary, the other errors (syntax, permission) would fail there too.
+func (sc *SQLConn) read(ctx context.Context, read func(db *gorm.DB) error) (err error) {
+	if !isPrimary(ctx) {
+		if r := sc.nextReplica(); r != nil {
+			db, errConnect := r.conn.connectWithRetry(ctx, false) //? Fail over now rather than after the retries
+			if errConnect == nil {
+				if err = read(db.WithContext(ctx)); err == nil || ctx.Err() != nil || !isConnectionError(err) {
+					return
+				}
+				errConnect = err
+			}
+			sc.markDown(r, errConnect)
+		}
+	}
+
+	db, err := sc.connect(ctx)
+	if err != nil {
+		log.Println("DB connection error:", err)
+		return
+	}
+	return read(db.WithContext(ctx))
+}
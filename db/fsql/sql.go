@@ -8,16 +8,14 @@ import (
 	"log"
 	"os"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
+	"unicode"
 
 	"github.com/dansbeer/go-forge/db/fredis"
 	"github.com/getsentry/sentry-go"
 	"github.com/logrusorgru/aurora"
-	"gorm.io/driver/clickhouse"
-	"gorm.io/driver/postgres"
-
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 type SQLConn_Type int
@@ -25,12 +23,18 @@ type SQLConn_Type int
 const (
 	SQLConn_Type_Clickhouse SQLConn_Type = iota
 	SQLConn_Type_Postgre
+	SQLConn_Type_MySQL //? Also MariaDB
+	SQLConn_Type_SQLite
+	SQLConn_Type_SQLServer
 )
 
 func (index SQLConn_Type) String() string {
 	return []string{
 		"clickhouse",
 		"postgre",
+		"mysql",
+		"sqlite",
+		"sqlserver",
 	}[index]
 }
 
@@ -43,40 +47,95 @@ type SQLConn struct {
 	ConnectionType SQLConn_Type
 	disableCache   bool
 	redisConn      *fredis.Redis
+	redisMutex     sync.Mutex
+	cachePolicy    CachePolicy
+	pool           PoolOption
+	shared         *sharedDB
+	listReplica    []*replica
+	replicaOption  ReplicaOption
+	replicaIndex   atomic.Uint64
+}
+
+// ? Any dsn of the driver, e.g. NewSQLConnFromDSN(SQLConn_Type_MySQL, "user:pwd@tcp(localhost:3306)/db?parseTime=true")
+func NewSQLConnFromDSN(connectionType SQLConn_Type, dsn string) *SQLConn {
+	return &SQLConn{ConnectionType: connectionType, dsn: dsn}
 }
 
-func BaseNewClickHouseFromEnv(host, db, user, pwd string) *SQLConn {
-	sqlConn := SQLConn{
+func newSQLConn(connectionType SQLConn_Type, host, db, user, pwd string) *SQLConn {
+	return &SQLConn{
 		Host:           host,
 		Db:             db,
 		User:           user,
 		Pwd:            pwd,
-		ConnectionType: SQLConn_Type_Clickhouse,
+		ConnectionType: connectionType,
 	}
-	sqlConn.dsn = fmt.Sprintf("clickhouse://%s:%s@%s/%s?read_timeout=30s", sqlConn.User, sqlConn.Pwd, sqlConn.Host, sqlConn.Db)
-	return &sqlConn
+}
+
+func BaseNewClickHouseFromEnv(host, db, user, pwd string, option ...DSNOption) *SQLConn {
+	sqlConn := newSQLConn(SQLConn_Type_Clickhouse, host, db, user, pwd)
+	sqlConn.dsn = clickHouseDSN(host, db, user, pwd, firstDSNOption(option))
+	return sqlConn
 }
 
 func NewClickHouseFromEnv() *SQLConn {
-	return BaseNewClickHouseFromEnv(os.Getenv("CH_HOST"), os.Getenv("CH_DB"), os.Getenv("CH_USER"), os.Getenv("CH_PWD"))
+	return BaseNewClickHouseFromEnv(os.Getenv("CH_HOST"), os.Getenv("CH_DB"), os.Getenv("CH_USER"), os.Getenv("CH_PWD"), dsnOptionFromEnv("CH"))
 }
 
-func BaseNewPostgreFromEnv(host, db, user, pwd string) *SQLConn {
-	sqlConn := SQLConn{
-		Host:           host,
-		Db:             db,
-		User:           user,
-		Pwd:            pwd,
-		ConnectionType: SQLConn_Type_Postgre,
-	}
-	splitHost := strings.Split(sqlConn.Host, ":")
-	sqlConn.dsn = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s",
-		splitHost[0], splitHost[1], sqlConn.User, sqlConn.Pwd, sqlConn.Db)
-	return &sqlConn
+// ? host without port = 5432
+func BaseNewPostgreFromEnv(host, db, user, pwd string, option ...DSNOption) *SQLConn {
+	sqlConn := newSQLConn(SQLConn_Type_Postgre, host, db, user, pwd)
+	sqlConn.dsn = postgreDSN(host, db, user, pwd, firstDSNOption(option))
+	return sqlConn
 }
 
+// ? POSTGRE_BT_REPLICA_HOST (comma separated) add read replicas with the same db, user and password.
 func NewPostgreFromEnv() *SQLConn {
-	return BaseNewPostgreFromEnv(os.Getenv("POSTGRE_BT_HOST"), os.Getenv("POSTGRE_BT_DB"), os.Getenv("POSTGRE_BT_USER"), os.Getenv("POSTGRE_BT_PWD"))
+	db, user, pwd, option := os.Getenv("POSTGRE_BT_DB"), os.Getenv("POSTGRE_BT_USER"), os.Getenv("POSTGRE_BT_PWD"), dsnOptionFromEnv("POSTGRE_BT")
+	res := BaseNewPostgreFromEnv(os.Getenv("POSTGRE_BT_HOST"), db, user, pwd, option)
+
+	var listReplica []*SQLConn
+	for _, host := range strings.Split(os.Getenv("POSTGRE_BT_REPLICA_HOST"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			listReplica = append(listReplica, BaseNewPostgreFromEnv(host, db, user, pwd, option))
+		}
+	}
+	if len(listReplica) > 0 {
+		res.SetReplica(listReplica...)
+	}
+	return res
+}
+
+// ? MySQL and MariaDB, host without port = 3306
+func BaseNewMySQLFromEnv(host, db, user, pwd string, option ...DSNOption) *SQLConn {
+	sqlConn := newSQLConn(SQLConn_Type_MySQL, host, db, user, pwd)
+	sqlConn.dsn = mySQLDSN(host, db, user, pwd, firstDSNOption(option))
+	return sqlConn
+}
+
+func NewMySQLFromEnv() *SQLConn {
+	return BaseNewMySQLFromEnv(os.Getenv("MYSQL_HOST"), os.Getenv("MYSQL_DB"), os.Getenv("MYSQL_USER"), os.Getenv("MYSQL_PWD"), dsnOptionFromEnv("MYSQL"))
+}
+
+// ? host without port = 1433
+func BaseNewSQLServerFromEnv(host, db, user, pwd string, option ...DSNOption) *SQLConn {
+	sqlConn := newSQLConn(SQLConn_Type_SQLServer, host, db, user, pwd)
+	sqlConn.dsn = sqlServerDSN(host, db, user, pwd, firstDSNOption(option))
+	return sqlConn
+}
+
+func NewSQLServerFromEnv() *SQLConn {
+	return BaseNewSQLServerFromEnv(os.Getenv("MSSQL_HOST"), os.Getenv("MSSQL_DB"), os.Getenv("MSSQL_USER"), os.Getenv("MSSQL_PWD"), dsnOptionFromEnv("MSSQL"))
+}
+
+// ? Local development and tests, pure Go (no cgo). path is a file or ":memory:".
+func BaseNewSQLiteFromEnv(path string, option ...DSNOption) *SQLConn {
+	sqlConn := newSQLConn(SQLConn_Type_SQLite, "", path, "", "")
+	sqlConn.dsn = sqliteDSN(path, firstDSNOption(option))
+	return sqlConn
+}
+
+func NewSQLiteFromEnv() *SQLConn {
+	return BaseNewSQLiteFromEnv(os.Getenv("SQLITE_PATH"), dsnOptionFromEnv("SQLITE"))
 }
 
 func NewSQLConn(connectionType SQLConn_Type) (res *SQLConn) {
@@ -85,6 +144,12 @@ func NewSQLConn(connectionType SQLConn_Type) (res *SQLConn) {
 		res = NewClickHouseFromEnv()
 	case SQLConn_Type_Postgre:
 		res = NewPostgreFromEnv()
+	case SQLConn_Type_MySQL:
+		res = NewMySQLFromEnv()
+	case SQLConn_Type_SQLite:
+		res = NewSQLiteFromEnv()
+	case SQLConn_Type_SQLServer:
+		res = NewSQLServerFromEnv()
 	}
 	return
 }
@@ -94,108 +159,92 @@ func (sc *SQLConn) SetDisableCache(disableCache bool) *SQLConn {
 	return sc
 }
 
+// ? Applied when the pool of the DSN is opened, the first SQLConn opening it wins.
+func (sc *SQLConn) SetPoolOption(pool PoolOption) *SQLConn {
+	sc.pool = pool
+	return sc
+}
+
 func (sc *SQLConn) SetRedisConn(redisConn *fredis.Redis) *SQLConn {
+	sc.redisMutex.Lock()
+	defer sc.redisMutex.Unlock()
 	sc.redisConn = redisConn
 	return sc
 }
 
-func (sc *SQLConn) connect() (db *gorm.DB, err error) {
-	switch sc.ConnectionType {
-	case SQLConn_Type_Clickhouse:
-		db, err = gorm.Open(clickhouse.Open(sc.dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
-		if err != nil {
-			fmt.Printf("o: %+v\n", sc)
-			if strings.Contains(err.Error(), "i/o timeout") {
-				return sc.connect()
-			}
-			return
-		}
-	case SQLConn_Type_Postgre:
-		db, err = gorm.Open(postgres.Open(sc.dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
-		if err != nil {
-			fmt.Printf("o: %+v\n", sc)
-			return
-		}
-	}
-	return
+func (sc *SQLConn) BaseExec(query string, ptrDecodeTo interface{}) (err error) {
+	return sc.BaseQuery(context.Background(), ptrDecodeTo, query)
 }
 
-func close(db *gorm.DB) {
-	dbClient, err := db.DB()
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	dbClient.Close()
+// ? Arguments are bound by the driver (? placeholder, or @name with sql.Named), never formatted into the query.
+// ? e.g. sc.BaseQuery(ctx, &listOutlet, "SELECT * FROM outlet WHERE city = ? AND active = ?", city, true)
+func (sc *SQLConn) BaseQuery(ctx context.Context, ptrDecodeTo any, query string, args ...any) (err error) {
+	log.Println("Executing Query:\n", aurora.Magenta(query), fmt.Sprintf("(%d args)", len(args))) //? The values may be personal data, not logged
+	return sc.cachedScan(ctx, ptrDecodeTo, query, args)
 }
 
-func (sc *SQLConn) BaseExec(query string, ptrDecodeTo interface{}) (err error) {
-	log.Println("Executing Query:\n", aurora.Magenta(query))
-
-	cacheKey := fmt.Sprintf("%s__%s", sc.ConnectionType, query)
-
-	// Jika caching dimatikan → langsung query ke database
-	if sc.disableCache {
-		var db *gorm.DB
-		db, err = sc.connect()
-		if err != nil {
-			log.Println("DB connection error:", err)
-			return
-		}
-		defer close(db)
+// ? Named parameter, e.g. "WHERE city = @city", map[string]any{"city": city}
+func (sc *SQLConn) BaseQueryNamed(ctx context.Context, ptrDecodeTo any, query string, params map[string]any) (err error) {
+	return sc.BaseQuery(ctx, ptrDecodeTo, query, params)
+}
 
-		if queryRes := db.Raw(query).Scan(ptrDecodeTo); queryRes.Error != nil {
+func (sc *SQLConn) rawScan(ctx context.Context, ptrDecodeTo any, query string, args []any) (err error) {
+	return sc.read(ctx, func(db *gorm.DB) error {
+		if queryRes := db.Raw(query, args...).Scan(ptrDecodeTo); queryRes.Error != nil {
 			log.Println("DB query error:", queryRes.Error)
 			return queryRes.Error
 		}
 		return nil
-	}
+	})
+}
 
-	// --- Jika caching diaktifkan ---
-	var redis *fredis.Redis
-	if sc.redisConn == nil {
-		redis, err = fredis.NewRedisCacheConnection()
-		if err != nil {
-			log.Println("Redis connection error:", err)
-			return
+// ? Whitespace outside of the string literals is collapsed, the same query written on several lines share the cache.
+func normalizeQuery(query string) string {
+	var res strings.Builder
+	var quote rune
+	space := false
+	for _, char := range strings.TrimSpace(query) {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case unicode.IsSpace(char):
+			space = true
+			continue
 		}
-	} else {
-		redis = sc.redisConn
+		if space {
+			res.WriteByte(' ')
+			space = false
+		}
+		res.WriteRune(char)
 	}
+	return res.String()
+}
 
-	// Cek apakah data ada di cache
-	if found := redis.Get(cacheKey, ptrDecodeTo); found {
-		fmt.Println(aurora.Red("CACHED"))
-		return nil
+func cacheKeyOf(query string, args []any) string {
+	query = normalizeQuery(query)
+	if len(args) == 0 {
+		return query
 	}
-
-	// Jika tidak ditemukan di cache → ambil dari DB
-	var db *gorm.DB
-	db, err = sc.connect()
+	rawArgs, err := json.Marshal(args)
 	if err != nil {
-		log.Println("DB connection error:", err)
-		return
-	}
-	defer close(db)
-
-	if queryRes := db.Raw(query).Scan(ptrDecodeTo); queryRes.Error != nil {
-		log.Println("DB query error:", queryRes.Error)
-		return queryRes.Error
-	}
-
-	// Simpan ke cache
-	res, _ := json.Marshal(ptrDecodeTo)
-	if err = redis.Set(cacheKey, string(res), 6*time.Hour); err != nil {
-		log.Println("Redis set error:", err)
+		rawArgs = []byte(fmt.Sprintf("%#v", args))
 	}
-
-	return nil
+	return query + "__" + string(rawArgs)
 }
 
 func (sc *SQLConn) Exec(query string) (res []byte, err error) {
+	return sc.Query(context.Background(), query)
+}
+
+// ? Same as Exec, with bound arguments.
+func (sc *SQLConn) Query(ctx context.Context, query string, args ...any) (res []byte, err error) {
 	var data []map[string]any
 	query = strings.ReplaceAll(query, "\n", " ")
-	if err = sc.BaseExec(query, &data); err != nil {
+	if err = sc.BaseQuery(ctx, &data, query, args...); err != nil {
 		log.Println(err)
 		return
 	}
@@ -208,23 +257,38 @@ func (sc *SQLConn) Exec(query string) (res []byte, err error) {
 	return
 }
 
+func (sc *SQLConn) QueryNamed(ctx context.Context, query string, params map[string]any) (res []byte, err error) {
+	return sc.Query(ctx, query, params)
+}
+
 func (sc *SQLConn) Insert(ptrStruct any) (err error) {
 	var db *gorm.DB
-	db, err = sc.connect()
+	db, err = sc.connect(context.Background())
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	defer close(db)
+	result := db.Create(ptrStruct)
+	if err = result.Error; err != nil {
+		log.Println(err)
+		return
+	}
 
-	db.Create(ptrStruct)
+	if !sc.disableCache && result.Statement.Table != "" {
+		sc.InvalidateTables(context.Background(), result.Statement.Table)
+	}
 	return
 }
 
 // ? don't forget to alias the field to 'total'
 func (sc *SQLConn) GetCount(query *bytes.Buffer) (res float64, err error) {
+	return sc.GetCountQuery(context.Background(), query.String())
+}
+
+// ? Same as GetCount, with bound arguments.
+func (sc *SQLConn) GetCountQuery(ctx context.Context, query string, args ...any) (res float64, err error) {
 	data := []map[string]any{}
-	if err = sc.BaseExec(query.String(), &data); err != nil && !strings.Contains(err.Error(), context.Canceled.Error()) {
+	if err = sc.BaseQuery(ctx, &data, query, args...); err != nil && !strings.Contains(err.Error(), context.Canceled.Error()) {
 		log.Println(err)
 		sentry.CaptureException(err)
 		return
@@ -247,3 +311,7 @@ func (sc *SQLConn) GetCount(query *bytes.Buffer) (res float64, err error) {
 	}
 	return
 }
+
+func (sc *SQLConn) GetCountNamed(ctx context.Context, query string, params map[string]any) (res float64, err error) {
+	return sc.GetCountQuery(ctx, query, params)
+}